@@ -0,0 +1,77 @@
+package msgpack
+
+import "context"
+
+// DecodeContext and UnmarshalContext check whether their context is done
+// every this many array elements and map entries
+const DEFAULT_CANCEL_INTERVAL = 1024
+
+// Stops a decode once its context is done
+type cancelCheck struct {
+	ctx context.Context
+	// elements left before ctx is checked again
+	countdown int
+}
+
+// what decode panics with when the context is done
+type canceled struct {
+	err error
+}
+
+func newCancelCheck(ctx context.Context) *cancelCheck {
+	return &cancelCheck{ctx: ctx, countdown: DEFAULT_CANCEL_INTERVAL}
+}
+
+// Counts an array element or map entry. Every DEFAULT_CANCEL_INTERVAL of
+// them, returns the context's error if it is done. A nil cancelCheck never
+// stops anything
+func (c *cancelCheck) next() error {
+	if c == nil {
+		return nil
+	}
+	c.countdown--
+	if c.countdown > 0 {
+		return nil
+	}
+	c.countdown = DEFAULT_CANCEL_INTERVAL
+	return c.ctx.Err()
+}
+
+// next, for decode, which panics instead of returning errors
+func (c *cancelCheck) element() {
+	if err := c.next(); err != nil {
+		panic(canceled{err})
+	}
+}
+
+// Like Decode, but gives up once @ctx is done, returning @offset, nil and
+// ctx.Err(). The context is checked every DEFAULT_CANCEL_INTERVAL array
+// elements and map entries, so large arrays and maps can be abandoned
+// part way through. Unlike Decode, DecodeContext also returns the reason it
+// couldn't decode a malformed object.
+func DecodeContext(ctx context.Context, input *[]byte, offset int) (int, interface{}, error) {
+	return defaultDecoder.DecodeContext(ctx, input, offset)
+}
+
+// Like the package-level DecodeContext, but with this Decoder's settings
+func (dec *Decoder) DecodeContext(ctx context.Context, input *[]byte, offset int) (int, interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return offset, nil, err
+	}
+	return dec.tryDecode(input, offset, 0, newCancelCheck(ctx))
+}
+
+// Like Unmarshal, but gives up once @ctx is done, returning ctx.Err(). @v
+// may have been partly filled in by then. The context is checked as
+// DecodeContext does
+func UnmarshalContext(ctx context.Context, data []byte, v interface{}) error {
+	return defaultDecoder.UnmarshalContext(ctx, data, v)
+}
+
+// Like the package-level UnmarshalContext, but with this Decoder's settings
+func (dec *Decoder) UnmarshalContext(ctx context.Context, data []byte, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return dec.unmarshalTop("UnmarshalContext", data, v, &decodeState{Decoder: dec, check: newCancelCheck(ctx)})
+}
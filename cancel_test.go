@@ -0,0 +1,61 @@
+package msgpack
+
+import (
+	"context"
+	"testing"
+)
+
+// A context that is canceled once Err has been called @live times
+type countdownContext struct {
+	context.Context
+	live int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.live == 0 {
+		return context.Canceled
+	}
+	ctx.live--
+	return nil
+}
+
+func TestDecodeContext(t *testing.T) {
+	arr := make([]interface{}, 10*DEFAULT_CANCEL_INTERVAL)
+	for i := range arr {
+		arr[i] = map[string]interface{}{"i": i}
+	}
+	bytes, err := Marshal(arr)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	end, value, err := DecodeContext(context.Background(), &bytes, 0)
+	if err != nil || end != len(bytes) || len(value.([]interface{})) != len(arr) {
+		t.Errorf("DecodeContext should decode everything with a live context: %d (%v)", end, err)
+	}
+	// live for the check before starting and the first few intervals
+	ctx := &countdownContext{Context: context.Background(), live: 3}
+	if end, value, err = DecodeContext(ctx, &bytes, 0); err != context.Canceled || end != 0 || value != nil {
+		t.Errorf("DecodeContext returned %v ending at %d (%v), want context.Canceled", value, end, err)
+	}
+
+	var ints []map[string]int
+	if err = UnmarshalContext(context.Background(), bytes, &ints); err != nil || len(ints) != len(arr) {
+		t.Errorf("UnmarshalContext should decode everything with a live context: %v", err)
+	}
+	ctx = &countdownContext{Context: context.Background(), live: 3}
+	if err = UnmarshalContext(ctx, bytes, &ints); err != context.Canceled {
+		t.Errorf("UnmarshalContext into a slice returned %v, want context.Canceled", err)
+	}
+	var any interface{}
+	ctx = &countdownContext{Context: context.Background(), live: 3}
+	if err = UnmarshalContext(ctx, bytes, &any); err != context.Canceled {
+		t.Errorf("UnmarshalContext into an interface{} returned %v, want context.Canceled", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err = DecodeContext(canceled, &bytes, 0); err != context.Canceled {
+		t.Errorf("DecodeContext should not start with a canceled context: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gtfierro/msgpack"
+)
+
+// at most this many bytes of an object are shown in hex
+const dumpBytes = 8
+
+// at most this many characters of a string are shown
+const dumpChars = 32
+
+type dumper struct {
+	w    *bufio.Writer
+	data []byte
+}
+
+// Writes one line per msgpack object in @data to @w: the offset, the
+// object's bytes in hex, and its format, indented by nesting depth, e.g.
+//
+//	00000000  81                          fixmap(1)
+//	00000001  a3 6b 65 79                   fixstr(3) "key"
+//	00000005  cd 01 00                      uint16 256
+//
+// Stops at the first malformed object, after writing everything before it.
+func dump(w io.Writer, data []byte) error {
+	d := &dumper{w: bufio.NewWriter(w), data: data}
+	offset := 0
+	for offset < len(data) {
+		var err error
+		if offset, err = d.object(offset, 0); err != nil {
+			d.w.Flush()
+			return err
+		}
+	}
+	return d.w.Flush()
+}
+
+// Dumps the object at @offset and everything inside it. Returns the offset
+// following it
+func (d *dumper) object(offset, depth int) (int, error) {
+	h, err := msgpack.ReadHeader(d.data, offset)
+	if err != nil {
+		return offset, err
+	}
+	if (h.Type == msgpack.ArrayType || h.Type == msgpack.MapType) && depth >= msgpack.DEFAULT_MAX_DEPTH {
+		// the error Decode gives for the same input
+		return offset, &msgpack.DecodeError{Offset: offset, Msg: "arrays and maps nested too deeply"}
+	}
+	end := offset + h.Size
+	if h.Type != msgpack.ArrayType && h.Type != msgpack.MapType {
+		end += h.Length
+	}
+	raw := d.data[offset:end]
+	hex := ""
+	if len(raw) > dumpBytes {
+		hex = fmt.Sprintf("% x ..", raw[:dumpBytes])
+	} else {
+		hex = fmt.Sprintf("% x", raw)
+	}
+	fmt.Fprintf(d.w, "%08x  %-26s  %s%s%s\n", offset, hex, strings.Repeat("  ", depth), h, d.preview(offset, h))
+
+	switch h.Type {
+	case msgpack.ArrayType:
+		for i := 0; i < h.Length; i++ {
+			if end, err = d.object(end, depth+1); err != nil {
+				return end, err
+			}
+		}
+	case msgpack.MapType:
+		for i := 0; i < 2*h.Length; i++ {
+			if end, err = d.object(end, depth+1); err != nil {
+				return end, err
+			}
+		}
+	}
+	return end, nil
+}
+
+// Returns the value of numbers and strings, to print after the format
+func (d *dumper) preview(offset int, h msgpack.Header) string {
+	switch h.Type {
+	case msgpack.IntType, msgpack.UintType, msgpack.FloatType:
+		_, value := msgpack.Decode(&d.data, offset)
+		return fmt.Sprintf(" %v", value)
+	case msgpack.StrType:
+		_, value := msgpack.Decode(&d.data, offset)
+		s := value.(string)
+		if len(s) > dumpChars {
+			return fmt.Sprintf(" %q..", s[:dumpChars])
+		}
+		return fmt.Sprintf(" %q", s)
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gtfierro/msgpack"
+)
+
+func TestDump(t *testing.T) {
+	//{'key':[256, nil, true]} followed by a bin8
+	data := []byte{0x81, 0xa3, 0x6b, 0x65, 0x79, 0x93, 0xcd, 0x1, 0x0, 0xc0, 0xc3, 0xc4, 0x9, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9}
+	expected := `00000000  81                          fixmap(1)
+00000001  a3 6b 65 79                   fixstr(3) "key"
+00000005  93                            fixarray(3)
+00000006  cd 01 00                        uint16 256
+00000009  c0                              nil
+0000000a  c3                              true
+0000000b  c4 09 01 02 03 04 05 06 ..  bin8(9)
+`
+	var out bytes.Buffer
+	if err := dump(&out, data); err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("dump should be\n%v\nbut was\n%v", expected, out.String())
+	}
+}
+
+func TestDumpTruncated(t *testing.T) {
+	data := []byte{0x92, 0x1, 0xcd}
+	var out bytes.Buffer
+	if err := dump(&out, data); err == nil {
+		t.Errorf("dump should fail on truncated input")
+	}
+	if out.Len() == 0 {
+		t.Errorf("dump should write the objects before the error")
+	}
+}
+
+func TestDumpTooDeep(t *testing.T) {
+	data := bytes.Repeat([]byte{0x91}, msgpack.DEFAULT_MAX_DEPTH+1)
+	data = append(data, 0xc0)
+	var out bytes.Buffer
+	if err := dump(&out, data); err == nil {
+		t.Errorf("dump should refuse arrays nested deeper than DEFAULT_MAX_DEPTH")
+	}
+	out.Reset()
+	if err := dump(&out, data[1:]); err != nil {
+		t.Errorf("dump should allow arrays nested DEFAULT_MAX_DEPTH deep: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Command msgpack inspects and converts msgpack data.
+//
+// Usage:
+//
+//	msgpack dump [file ...]       annotated hex dump with offsets and format names
+//	msgpack json [-indent] [file ...]  convert msgpack to JSON
+//	msgpack from-json [file ...]  convert JSON to msgpack
+//	msgpack validate [file ...]   check that the input is well-formed msgpack
+//	msgpack structs [-name Message] [-package main] [file ...]
+//	                              write Go structs that fit the sample messages
+//
+// With no files, input is read from stdin. Output goes to stdout.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/gtfierro/msgpack"
+)
+
+var commands = map[string]func(args []string) error{
+	"dump":      dumpCommand,
+	"json":      jsonCommand,
+	"from-json": fromJSONCommand,
+	"validate":  validateCommand,
+	"structs":   structsCommand,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: msgpack <command> [flags] [file ...]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  dump       annotated hex dump with offsets and format names\n")
+	fmt.Fprintf(os.Stderr, "  json       convert msgpack to JSON\n")
+	fmt.Fprintf(os.Stderr, "  from-json  convert JSON to msgpack\n")
+	fmt.Fprintf(os.Stderr, "  validate   check that the input is well-formed msgpack\n")
+	fmt.Fprintf(os.Stderr, "  structs    write Go structs that fit the sample messages\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command, found := commands[os.Args[1]]
+	if !found {
+		usage()
+	}
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "msgpack %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// Calls @fn with the contents of each named file, or of stdin if there are none
+func eachInput(files []string, fn func(name string, data []byte) error) error {
+	if len(files) == 0 {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return fn("stdin", data)
+	}
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err = fn(name, data); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func dumpCommand(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	flags.Parse(args)
+	return eachInput(flags.Args(), func(name string, data []byte) error {
+		return dump(os.Stdout, data)
+	})
+}
+
+func jsonCommand(args []string) error {
+	flags := flag.NewFlagSet("json", flag.ExitOnError)
+	indent := flags.Bool("indent", false, "indent the JSON output")
+	flags.Parse(args)
+	return eachInput(flags.Args(), func(name string, data []byte) error {
+		if !*indent {
+			return msgpack.ToJSON(data, os.Stdout)
+		}
+		var compact, indented bytes.Buffer
+		if err := msgpack.ToJSON(data, &compact); err != nil {
+			return err
+		}
+		// ToJSON writes one value per line
+		for _, line := range bytes.Split(bytes.TrimSpace(compact.Bytes()), []byte("\n")) {
+			indented.Reset()
+			if err := json.Indent(&indented, line, "", "  "); err != nil {
+				return err
+			}
+			indented.WriteByte('\n')
+			if _, err := indented.WriteTo(os.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func fromJSONCommand(args []string) error {
+	flags := flag.NewFlagSet("from-json", flag.ExitOnError)
+	flags.Parse(args)
+	convert := func(r io.Reader) error {
+		packed, err := msgpack.FromJSON(r, nil)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(packed)
+		return err
+	}
+	if flags.NArg() == 0 {
+		return convert(os.Stdin)
+	}
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = convert(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func validateCommand(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Parse(args)
+	return eachInput(flags.Args(), func(name string, data []byte) error {
+		if err := msgpack.Validate(data); err != nil {
+			return err
+		}
+		fmt.Printf("%s: ok\n", name)
+		return nil
+	})
+}
+
+// Every object in every input is a sample of the same message. Keys missing
+// from some samples, or nil in some, become omitempty fields, and pointers
+// if they are numbers, strings or booleans. Integer fields get the smallest
+// type that holds every value seen, so widen them if the samples don't
+// cover the full range.
+func structsCommand(args []string) error {
+	flags := flag.NewFlagSet("structs", flag.ExitOnError)
+	name := flags.String("name", "Message", "name of the top-level type")
+	pkg := flags.String("package", "main", "package clause of the output")
+	flags.Parse(args)
+	samples := &shape{}
+	err := eachInput(flags.Args(), func(name string, data []byte) error {
+		return observeAll(samples, data)
+	})
+	if err != nil {
+		return err
+	}
+	if samples.seen == 0 {
+		return fmt.Errorf("no samples")
+	}
+	source, err := generateStructs(samples, *name, *pkg)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(source)
+	return err
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gtfierro/msgpack"
+)
+
+// What has been seen of one value across all of the samples: how often it
+// was there, which types it had, and for maps and arrays, what was in them
+type shape struct {
+	// samples the value was there in, nil or not
+	seen int
+	// how many times each type was seen
+	nils, bools, ints, floats, strs, bins, arrays, maps int
+	// how many of the floats were float32s
+	float32s int
+	// the range of the ints. min is only set if one was negative
+	min int64
+	max uint64
+	// what was seen of the elements of all the arrays
+	elem *shape
+	// the keys of the maps in the order they were first seen, and what was
+	// seen of their values
+	keys   []string
+	fields map[string]*shape
+	// what was seen of all the values of the maps, for maps that are used
+	// as dictionaries rather than records
+	values *shape
+}
+
+// Records one more sample of the value described by @s from the object at
+// @offset in @data, which is known to decode, and returns the offset
+// following it. The bytes are walked rather than the decoded value so that
+// float32s can be told apart from float64s
+func (s *shape) observe(data []byte, offset int) int {
+	s.seen++
+	h, _ := msgpack.ReadHeader(data, offset)
+	switch h.Type {
+	case msgpack.ArrayType:
+		s.arrays++
+		if s.elem == nil {
+			s.elem = &shape{}
+		}
+		offset += h.Size
+		for i := 0; i < h.Length; i++ {
+			offset = s.elem.observe(data, offset)
+		}
+		return offset
+	case msgpack.MapType:
+		s.maps++
+		if s.fields == nil {
+			s.fields = make(map[string]*shape)
+			s.values = &shape{}
+		}
+		offset += h.Size
+		inMap := make(map[string]bool, h.Length)
+		for i := 0; i < h.Length; i++ {
+			next, decoded := msgpack.Decode(&data, offset)
+			key, _ := decoded.(string)
+			if inMap[key] {
+				// a repeated key is one field, so only its first value counts
+				offset, _ = msgpack.Skip(data, next)
+				continue
+			}
+			inMap[key] = true
+			field, found := s.fields[key]
+			if !found {
+				field = &shape{}
+				s.fields[key] = field
+				s.keys = append(s.keys, key)
+			}
+			field.observe(data, next)
+			offset = s.values.observe(data, next)
+		}
+		return offset
+	case msgpack.FloatType:
+		if h.Length == 4 {
+			s.float32s++
+		}
+	}
+	end, value := msgpack.Decode(&data, offset)
+	switch v := value.(type) {
+	case nil:
+		s.nils++
+	case bool:
+		s.bools++
+	case int64:
+		s.ints++
+		if v < 0 {
+			if v < s.min {
+				s.min = v
+			}
+		} else if uint64(v) > s.max {
+			s.max = uint64(v)
+		}
+	case uint64:
+		s.ints++
+		if v > s.max {
+			s.max = v
+		}
+	case float64:
+		s.floats++
+	case string:
+		s.strs++
+	case []byte:
+		s.bins++
+	}
+	return end
+}
+
+// Returns the number of different types seen, not counting nil
+func (s *shape) kinds() int {
+	n := 0
+	for _, count := range []int{s.bools, s.ints, s.floats, s.strs, s.bins, s.arrays, s.maps} {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns true if the map keys seen can't all be turned into field names,
+// so the maps should be Go maps instead of structs
+func (s *shape) isDictionary() bool {
+	if len(s.keys) == 0 {
+		return true
+	}
+	for _, key := range s.keys {
+		// a comma would be read as the start of the tag's options
+		if strings.IndexFunc(key, unicode.IsLetter) < 0 || strings.Contains(key, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the smallest Go integer type that holds every int seen. Unsigned
+// types are used unless there was a negative one
+func (s *shape) intType() string {
+	if s.min < 0 {
+		for _, bits := range []uint{8, 16, 32} {
+			if s.min >= -1<<(bits-1) && s.max <= 1<<(bits-1)-1 {
+				return fmt.Sprintf("int%d", bits)
+			}
+		}
+		if s.max <= math.MaxInt64 {
+			return "int64"
+		}
+		// both negative and past the range of int64
+		return "float64"
+	}
+	for _, bits := range []uint{8, 16, 32} {
+		if s.max <= 1<<bits-1 {
+			return fmt.Sprintf("uint%d", bits)
+		}
+	}
+	return "uint64"
+}
+
+// A struct type still to be written
+type pendingStruct struct {
+	name  string
+	shape *shape
+}
+
+// Writes Go types for shapes
+type generator struct {
+	buf bytes.Buffer
+	// type names already used
+	taken   map[string]bool
+	pending []pendingStruct
+}
+
+// Returns Go source declaring type @name for values like those seen in
+// @s, and the struct types it needs, in package @pkg
+func generateStructs(s *shape, name, pkg string) ([]byte, error) {
+	g := &generator{taken: make(map[string]bool)}
+	fmt.Fprintf(&g.buf, "package %s\n\n", pkg)
+	if s.maps > 0 && s.kinds() == 1 && !s.isDictionary() {
+		g.taken[name] = true
+		g.pending = append(g.pending, pendingStruct{name, s})
+	} else {
+		fmt.Fprintf(&g.buf, "type %s %s\n\n", name, g.goType(s, name))
+	}
+	for len(g.pending) > 0 {
+		next := g.pending[0]
+		g.pending = g.pending[1:]
+		g.writeStruct(next.name, next.shape)
+	}
+	return format.Source(g.buf.Bytes())
+}
+
+func (g *generator) writeStruct(name string, s *shape) {
+	fmt.Fprintf(&g.buf, "type %s struct {\n", name)
+	used := make(map[string]bool)
+	for _, key := range s.keys {
+		field := s.fields[key]
+		fieldname := uniqueName(fieldName(key), used)
+		used[fieldname] = true
+		// missing from some maps, or nil in some
+		optional := field.seen < s.maps || field.nils > 0
+		typ := g.goType(field, name+fieldname)
+		tag := key
+		if optional {
+			tag += ",omitempty"
+			if isScalar(typ) && field.kinds() > 0 {
+				typ = "*" + typ
+			}
+		}
+		fmt.Fprintf(&g.buf, "\t%s %s %s\n", fieldname, typ, tagLiteral(fmt.Sprintf("msgpack:%q", tag)))
+	}
+	fmt.Fprintf(&g.buf, "}\n\n")
+}
+
+// Returns the Go type for values like those seen in @s. Maps that become
+// structs are named @name
+func (g *generator) goType(s *shape, name string) string {
+	switch {
+	case s.kinds() == 0:
+		return "interface{}"
+	case s.kinds() == 2 && s.ints > 0 && s.floats > 0:
+		return "float64"
+	case s.kinds() == 2 && s.strs > 0 && s.bins > 0:
+		return "string"
+	case s.kinds() > 1:
+		return "interface{}"
+	case s.bools > 0:
+		return "bool"
+	case s.ints > 0:
+		return s.intType()
+	case s.floats > 0 && s.float32s == s.floats:
+		return "float32"
+	case s.floats > 0:
+		return "float64"
+	case s.strs > 0:
+		return "string"
+	case s.bins > 0:
+		return "[]byte"
+	case s.arrays > 0:
+		return "[]" + g.goType(s.elem, name)
+	}
+	if s.isDictionary() {
+		return "map[string]" + g.goType(s.values, name)
+	}
+	name = uniqueName(name, g.taken)
+	g.taken[name] = true
+	g.pending = append(g.pending, pendingStruct{name, s})
+	return name
+}
+
+// Returns @tag as a Go string literal: raw like the tags people write, unless
+// it holds a backquote, which only an interpreted literal can
+func tagLiteral(tag string) string {
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
+}
+
+func isScalar(typ string) bool {
+	return !strings.HasPrefix(typ, "[]") && !strings.HasPrefix(typ, "map[") && typ != "interface{}"
+}
+
+// words that are written in all capitals in Go names
+var initialisms = map[string]bool{
+	"API": true, "HTTP": true, "ID": true, "IP": true, "JSON": true,
+	"URL": true, "URI": true, "UUID": true, "UTC": true,
+}
+
+// Turns the map key @key into an exported Go name, e.g. "device_id" into
+// "DeviceID" and "tempC" into "TempC"
+func fieldName(key string) string {
+	var words []string
+	var word []rune
+	for i, r := range key {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			words, word = appendWord(words, word), nil
+		case unicode.IsUpper(r) && i > 0 && len(word) > 0 && !unicode.IsUpper(word[len(word)-1]):
+			words, word = appendWord(words, word), []rune{r}
+		default:
+			word = append(word, r)
+		}
+	}
+	words = appendWord(words, word)
+	var name strings.Builder
+	for _, w := range words {
+		if upper := strings.ToUpper(w); initialisms[upper] {
+			name.WriteString(upper)
+			continue
+		}
+		runes := []rune(w)
+		runes[0] = unicode.ToUpper(runes[0])
+		name.WriteString(string(runes))
+	}
+	if name.Len() == 0 || !unicode.IsLetter([]rune(name.String())[0]) {
+		return "F" + name.String()
+	}
+	return name.String()
+}
+
+func appendWord(words []string, word []rune) []string {
+	if len(word) == 0 {
+		return words
+	}
+	return append(words, string(word))
+}
+
+// Returns @name, or if it is taken, @name followed by the first number
+// that makes it unique
+func uniqueName(name string, taken map[string]bool) string {
+	if !taken[name] {
+		return name
+	}
+	for i := 2; ; i++ {
+		numbered := fmt.Sprintf("%s%d", name, i)
+		if !taken[numbered] {
+			return numbered
+		}
+	}
+}
+
+// Decodes every object in @data and records it as a sample in @s
+func observeAll(s *shape, data []byte) error {
+	dec := &msgpack.Decoder{OrderedMaps: true}
+	offset := 0
+	for offset < len(data) {
+		if _, _, err := dec.TryDecode(&data, offset); err != nil {
+			return fmt.Errorf("object at offset %d: %v", offset, err)
+		}
+		offset = s.observe(data, offset)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gtfierro/msgpack"
+)
+
+func TestGenerateStructs(t *testing.T) {
+	var samples []byte
+	for _, sample := range []msgpack.OrderedMap{
+		{
+			{Key: "device_id", Value: "a1"},
+			{Key: "seq", Value: 300},
+			{Key: "temp", Value: 21},
+			{Key: "location", Value: msgpack.OrderedMap{{Key: "lat", Value: 1.5}, {Key: "lon", Value: 2.5}}},
+			{Key: "tags", Value: []interface{}{"x"}},
+			{Key: "counts", Value: map[string]interface{}{"1": 2}},
+		},
+		{
+			{Key: "device_id", Value: "a2"},
+			{Key: "seq", Value: 70000},
+			{Key: "temp", Value: 20.5},
+			{Key: "battery", Value: nil},
+			{Key: "location", Value: msgpack.OrderedMap{{Key: "lat", Value: 1.5}, {Key: "lon", Value: 2.5}}},
+		},
+		{
+			{Key: "device_id", Value: "a3"},
+			{Key: "seq", Value: 1},
+			{Key: "temp", Value: 19},
+			{Key: "battery", Value: true},
+		},
+	} {
+		bytes, err := msgpack.Marshal(sample)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		samples = append(samples, bytes...)
+	}
+	s := &shape{}
+	if err := observeAll(s, samples); err != nil {
+		t.Fatalf("observeAll failed: %v", err)
+	}
+	source, err := generateStructs(s, "Reading", "devices")
+	if err != nil {
+		t.Fatalf("generateStructs failed: %v", err)
+	}
+	expected := "package devices\n\n" +
+		"type Reading struct {\n" +
+		"\tDeviceID string           `msgpack:\"device_id\"`\n" +
+		"\tSeq      uint32           `msgpack:\"seq\"`\n" +
+		"\tTemp     float64          `msgpack:\"temp\"`\n" +
+		"\tLocation *ReadingLocation `msgpack:\"location,omitempty\"`\n" +
+		"\tTags     []string         `msgpack:\"tags,omitempty\"`\n" +
+		"\tCounts   map[string]uint8 `msgpack:\"counts,omitempty\"`\n" +
+		"\tBattery  *bool            `msgpack:\"battery,omitempty\"`\n" +
+		"}\n\n" +
+		"type ReadingLocation struct {\n" +
+		"\tLat float64 `msgpack:\"lat\"`\n" +
+		"\tLon float64 `msgpack:\"lon\"`\n" +
+		"}\n"
+	if string(source) != expected {
+		t.Errorf("Generated\n%s\nwant\n%s", source, expected)
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	for key, want := range map[string]string{
+		"device_id": "DeviceID",
+		"tempC":     "TempC",
+		"HTTPCode":  "HTTPCode",
+		"2fa":       "F2fa",
+		"url":       "URL",
+	} {
+		if got := fieldName(key); got != want {
+			t.Errorf("fieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestObserveExt(t *testing.T) {
+	if err := observeAll(&shape{}, []byte{0xd4, 0x1, 0x0}); err == nil {
+		t.Errorf("observeAll should fail on what Decode can't handle")
+	}
+}
+
+func TestGenerateFloat32AndOddKeys(t *testing.T) {
+	samples := []byte{
+		0x83,
+		0xa1, 'x', 0xca, 0x3f, 0xc0, 0x00, 0x00, // "x": float32 1.5
+		0xa3, 'a', '`', 'b', 0x01, // "a`b": 1
+		0xa1, 'y', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, // "y": float64 1.5
+		0x82,
+		0xa1, 'x', 0xca, 0x40, 0x20, 0x00, 0x00, // "x": float32 2.5
+		0xa1, 'y', 0xca, 0x40, 0x20, 0x00, 0x00, // "y": float32 2.5
+	}
+	s := &shape{}
+	if err := observeAll(s, samples); err != nil {
+		t.Fatalf("observeAll failed: %v", err)
+	}
+	source, err := generateStructs(s, "Point", "points")
+	if err != nil {
+		t.Fatalf("generateStructs failed: %v", err)
+	}
+	expected := "package points\n\n" +
+		"type Point struct {\n" +
+		"\tX  float32 `msgpack:\"x\"`\n" +
+		"\tAB *uint8  \"msgpack:\\\"a`b,omitempty\\\"\"\n" +
+		"\tY  float64 `msgpack:\"y\"`\n" +
+		"}\n"
+	if string(source) != expected {
+		t.Errorf("Generated\n%s\nwant\n%s", source, expected)
+	}
+	s = &shape{}
+	if err := observeAll(s, []byte{0x81, 0xa3, 'a', ',', 'b', 0x01}); err != nil {
+		t.Fatalf("observeAll failed: %v", err)
+	}
+	if !s.isDictionary() {
+		t.Errorf("a key with a comma can't be a field, so the map should be a dictionary")
+	}
+}
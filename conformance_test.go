@@ -0,0 +1,310 @@
+package msgpack
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test vectors in the layout of msgpack-test-suite: groups of cases, each
+// with a value under a key naming its kind and every valid encoding of that
+// value. Every encoding has to decode to the value, and encoding the value
+// has to give one of them. Numbers are ints unless they have a fraction or
+// exponent; bignums, and floats JSON can't hold, are written as strings.
+//
+// Decode doesn't support ext, timestamps included, so for those every
+// encoding has to be read by ReadHeader as the listed type and data, be
+// refused by Decode, and come back out of Marshal unchanged as a Raw.
+//
+// testdata/msgpack-test-suite.json holds the groups of
+// https://github.com/kawanet/msgpack-test-suite (MIT license), in the
+// layout of its dist/msgpack-test-suite.json. It was transcribed from the
+// suite's source files rather than copied, so when updating it, diff it
+// against that file. testdata/conformance.json
+// holds this package's own cases: more boundaries, NaN and the infinities,
+// long strings and deeper nesting.
+var conformanceFiles = map[string][]byte{
+	"msgpack-test-suite.json": suiteVectors,
+	"conformance.json":        conformanceVectors,
+}
+
+//go:embed testdata/msgpack-test-suite.json
+var suiteVectors []byte
+
+//go:embed testdata/conformance.json
+var conformanceVectors []byte
+
+func parseHex(s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Returns the Go value Marshal should be given for a JSON value from the
+// vectors
+func conformanceValue(kind string, want interface{}) interface{} {
+	switch kind {
+	case "binary":
+		return parseHex(want.(string))
+	case "bignum":
+		return conformanceNumber(json.Number(want.(string)))
+	case "float":
+		if want.(string) == "NaN" {
+			// the quiet NaN the vectors list, rather than math.NaN()'s
+			return math.Float64frombits(0x7ff8000000000000)
+		}
+		f, _ := strconv.ParseFloat(want.(string), 64)
+		return f
+	}
+	switch w := want.(type) {
+	case json.Number:
+		return conformanceNumber(w)
+	case []interface{}:
+		values := make([]interface{}, len(w))
+		for i := range w {
+			values[i] = conformanceValue("", w[i])
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(w))
+		for k, v := range w {
+			values[k] = conformanceValue("", v)
+		}
+		return values
+	}
+	return want
+}
+
+func conformanceNumber(n json.Number) interface{} {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u
+	}
+	f, _ := strconv.ParseFloat(string(n), 64)
+	return f
+}
+
+// Returns true if @decoded, from Decode, is the value @want. Ints, uints
+// and floats are equal if they hold the same number
+func conformanceMatch(decoded, want interface{}) bool {
+	switch w := want.(type) {
+	case int64, uint64, float64:
+		return numberString(decoded) == numberString(w)
+	case []interface{}:
+		d, ok := decoded.([]interface{})
+		if !ok || len(d) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !conformanceMatch(d[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		d, ok := decoded.(map[string]interface{})
+		if !ok || len(d) != len(w) {
+			return false
+		}
+		for k := range w {
+			if !conformanceMatch(d[k], w[k]) {
+				return false
+			}
+		}
+		return true
+	case []byte:
+		d, ok := decoded.([]byte)
+		return ok && bytes.Equal(d, w)
+	}
+	return decoded == want
+}
+
+// Writes a number exactly, whatever its type. Floats that hold integers
+// are written as integers
+func numberString(n interface{}) string {
+	switch v := n.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return "not a number"
+}
+
+func TestConformance(t *testing.T) {
+	for file, vectors := range conformanceFiles {
+		d := json.NewDecoder(bytes.NewReader(vectors))
+		d.UseNumber()
+		var groups map[string][]map[string]interface{}
+		if err := d.Decode(&groups); err != nil {
+			t.Fatalf("Couldn't read %s: %v", file, err)
+		}
+		names := make([]string, 0, len(groups))
+		for name := range groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for i, c := range groups[name] {
+				testConformanceCase(t, fmt.Sprintf("%s %s #%d", file, name, i), c)
+			}
+		}
+	}
+}
+
+func testConformanceCase(t *testing.T, name string, c map[string]interface{}) {
+	var kind string
+	var encodings []string
+	for k, v := range c {
+		if k == "msgpack" {
+			for _, e := range v.([]interface{}) {
+				encodings = append(encodings, e.(string))
+			}
+		} else {
+			kind = k
+		}
+	}
+	if kind == "ext" || kind == "timestamp" {
+		testConformanceExt(t, name, kind, c[kind].([]interface{}), encodings)
+		return
+	}
+	want := conformanceValue(kind, c[kind])
+
+	for _, e := range encodings {
+		input := parseHex(e)
+		end, decoded := Decode(&input, 0)
+		if end != len(input) || !conformanceMatch(decoded, want) || kind == "float" && !sameFloat(decoded, want) {
+			t.Errorf("%s: Decode(%s) = %#v ending at %d, want %#v", name, e, decoded, end, want)
+		}
+	}
+
+	encoded, err := Marshal(want)
+	if err != nil {
+		t.Errorf("%s: Marshal(%#v) failed: %v", name, want, err)
+		return
+	}
+	if !conformanceListed(encoded, encodings) {
+		t.Errorf("%s: Marshal(%#v) = %x, want one of %v", name, want, encoded, encodings)
+	}
+	// floats that fit are also checked as float32
+	if f, ok := want.(float64); ok && strings.HasPrefix(encodings[0], "ca") {
+		if encoded, err = Marshal(float32(f)); err != nil || !conformanceListed(encoded, encodings) {
+			t.Errorf("%s: Marshal(float32(%v)) = %x (%v), want one of %v", name, f, encoded, err, encodings)
+		}
+	}
+}
+
+// Returns true if @decoded is the float64 @want, down to the sign of zero.
+// All NaNs are the same
+func sameFloat(decoded, want interface{}) bool {
+	d, ok := decoded.(float64)
+	w := want.(float64)
+	return ok && (math.Float64bits(d) == math.Float64bits(w) || math.IsNaN(d) && math.IsNaN(w))
+}
+
+// Checks the encodings of an ext, whose value is its type and data, or of
+// a timestamp, whose value is its seconds and nanoseconds
+func testConformanceExt(t *testing.T, name, kind string, value []interface{}, encodings []string) {
+	for _, e := range encodings {
+		input := parseHex(e)
+		if end, err := Skip(input, 0); err != nil || end != len(input) {
+			t.Errorf("%s: Skip(%s) ended at %d (%v)", name, e, end, err)
+			continue
+		}
+		if end, _ := Decode(&input, 0); end != 0 {
+			t.Errorf("%s: Decode(%s) should refuse ext", name, e)
+		}
+		h, err := ReadHeader(input, 0)
+		data := input[h.Size:]
+		if err != nil || h.Type != ExtType || h.Length != len(data) {
+			t.Errorf("%s: ReadHeader(%s) = %v (%v)", name, e, h, err)
+			continue
+		}
+		if kind == "ext" {
+			typ, _ := value[0].(json.Number).Int64()
+			if int64(h.Ext) != typ || !bytes.Equal(data, parseHex(value[1].(string))) {
+				t.Errorf("%s: %s has type %d and data %x, want %v", name, e, h.Ext, data, value)
+			}
+		} else {
+			sec, nsec, ok := timestampData(data)
+			if h.Ext != -1 || !ok || json.Number(strconv.FormatInt(sec, 10)) != value[0] ||
+				json.Number(strconv.FormatInt(int64(nsec), 10)) != value[1] {
+				t.Errorf("%s: %s has type %d and time %d.%09d, want %v", name, e, h.Ext, sec, nsec, value)
+			}
+		}
+		var raw Raw
+		if err := Unmarshal(input, &raw); err != nil {
+			t.Errorf("%s: Unmarshal(%s) into a Raw failed: %v", name, e, err)
+			continue
+		}
+		if encoded, err := Marshal(raw); err != nil || !bytes.Equal(encoded, input) {
+			t.Errorf("%s: Marshal(Raw(%s)) = %x (%v)", name, e, encoded, err)
+		}
+	}
+}
+
+// Reads the data of a timestamp ext in any of its three sizes
+func timestampData(data []byte) (sec int64, nsec uint32, ok bool) {
+	switch len(data) {
+	case 4:
+		return int64(binary.BigEndian.Uint32(data)), 0, true
+	case 8:
+		n := binary.BigEndian.Uint64(data)
+		return int64(n & (1<<34 - 1)), uint32(n >> 34), true
+	case 12:
+		return int64(binary.BigEndian.Uint64(data[4:])), binary.BigEndian.Uint32(data), true
+	}
+	return 0, 0, false
+}
+
+func conformanceListed(encoded []byte, encodings []string) bool {
+	for _, e := range encodings {
+		if bytes.Equal(encoded, parseHex(e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lengths too long to list in the vectors
+func TestConformanceLongLengths(t *testing.T) {
+	for _, test := range []struct {
+		value  interface{}
+		header []byte
+	}{
+		{strings.Repeat("s", 65535), []byte{0xda, 0xff, 0xff}},
+		{strings.Repeat("s", 65536), []byte{0xdb, 0x0, 0x1, 0x0, 0x0}},
+		{make([]byte, 65536), []byte{0xc6, 0x0, 0x1, 0x0, 0x0}},
+		{make([]interface{}, 65535), []byte{0xdc, 0xff, 0xff}},
+		{make([]interface{}, 65536), []byte{0xdd, 0x0, 0x1, 0x0, 0x0}},
+	} {
+		encoded, err := Marshal(test.value)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !bytes.HasPrefix(encoded, test.header) {
+			t.Errorf("%T of length %d should start with %x, not %x", test.value, len(encoded)-len(test.header), test.header, encoded[:len(test.header)])
+		}
+		end, decoded := Decode(&encoded, 0)
+		if end != len(encoded) || !conformanceMatch(decoded, test.value) {
+			t.Errorf("%T of length %d didn't decode back", test.value, len(encoded)-len(test.header))
+		}
+	}
+}
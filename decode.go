@@ -2,8 +2,9 @@ package msgpack
 
 import (
 	"encoding/binary"
-	"log"
+	"fmt"
 	"math"
+	"unicode/utf8"
 )
 
 func getUint(input *[]byte, offset, length int) uint64 {
@@ -51,7 +52,7 @@ func parseInt(input *[]byte, offset int) (int64, int) {
 		consumed = 1
 		goto ret
 	case 0xe0 <= c && c <= 0xff:
-		value = -int64(c & 0x1f)
+		value = int64(int8(c))
 		consumed = 1
 		goto ret
 	case c == 0xd0:
@@ -67,7 +68,7 @@ func parseInt(input *[]byte, offset int) (int64, int) {
 		tmp = getUint(input, offset+1, 8)
 		consumed = 9
 	default:
-		log.Panicf("UNKNOWN int: %v", c)
+		panic(&DecodeError{Offset: offset, Msg: fmt.Sprintf("format byte 0x%x is not an int", c)})
 	}
 	shift = (uint(consumed) - 1) * 8
 	if (tmp & (1 << (shift - 1))) != 0 {
@@ -99,9 +100,10 @@ func parseFloat(input *[]byte, offset int) (float64, int) {
 	return value, consumed
 }
 
-func parseString(input *[]byte, offset int) (string, int) {
+// Returns the bytes of the string starting at @offset without copying them
+func stringBytes(input *[]byte, offset int) ([]byte, int) {
 	var (
-		value    string
+		value    []byte
 		consumed int
 		length   int
 	)
@@ -109,25 +111,66 @@ func parseString(input *[]byte, offset int) (string, int) {
 	switch {
 	case c >= 0xa0 && c <= 0xbf:
 		length = int(c & 0x1f)
-		value = string((*input)[offset+1 : offset+1+length])
+		value = (*input)[offset+1 : offset+1+length]
 		consumed = length + 1
 	case c == 0xd9:
 		length = int((*input)[offset+1])
-		value = string((*input)[offset+2 : offset+2+length])
+		value = (*input)[offset+2 : offset+2+length]
 		consumed = length + 2
 	case c == 0xda:
 		length = int(getUint(input, offset+1, 2))
-		value = string((*input)[offset+3 : offset+3+length])
+		value = (*input)[offset+3 : offset+3+length]
 		consumed = length + 3
 	case c == 0xdb:
 		length = int(getUint(input, offset+1, 4))
-		value = string((*input)[offset+5 : offset+5+length])
+		value = (*input)[offset+5 : offset+5+length]
 		consumed = length + 5
 	}
 	return value, consumed
 }
 
-func parseMap(input *[]byte, offset int) (map[string]interface{}, int) {
+// Returns a copy of the bin starting at @offset
+func parseBin(input *[]byte, offset int) ([]byte, int) {
+	var length, headerlen int
+	switch (*input)[offset] {
+	case 0xc4:
+		length, headerlen = int((*input)[offset+1]), 2
+	case 0xc5:
+		length, headerlen = int(getUint(input, offset+1, 2)), 3
+	case 0xc6:
+		length, headerlen = int(getUint(input, offset+1, 4)), 5
+	}
+	start := offset + headerlen
+	return append([]byte{}, (*input)[start:start+length]...), headerlen + length
+}
+
+func parseString(input *[]byte, offset int) (string, int) {
+	value, consumed := stringBytes(input, offset)
+	return string(value), consumed
+}
+
+func isString(c byte) bool {
+	return (0xa0 <= c && c <= 0xbf) || c == 0xd9 || c == 0xda || c == 0xdb
+}
+
+// Decodes the map key at @offset, which has to be a string
+func (dec *Decoder) parseMapKey(input *[]byte, offset int) (string, int) {
+	h, err := ReadHeader(*input, offset)
+	if err != nil {
+		panic(err)
+	}
+	if h.Type != StrType {
+		panic(&DecodeError{Offset: offset, Msg: fmt.Sprintf("cannot decode %v map key", h.Type)})
+	}
+	if dec.InvalidUTF8 != AllowInvalidUTF8 {
+		if raw, _ := stringBytes(input, offset); !utf8.Valid(raw) {
+			panic(invalidUTF8Error(offset))
+		}
+	}
+	return dec.parseKey(input, offset)
+}
+
+func (dec *Decoder) parseMap(input *[]byte, offset int, depth int, check *cancelCheck) (map[string]interface{}, int) {
 	var (
 		value  map[string]interface{}
 		length int
@@ -145,25 +188,31 @@ func parseMap(input *[]byte, offset int) (map[string]interface{}, int) {
 		length = int(getUint(input, offset+1, 4))
 		offset += 5
 	}
-	value = make(map[string]interface{}, length)
+	value = getNewMap(length)
 	// get both a key and value for [length] elements
 	for mapidx := 0; mapidx < length; mapidx++ {
-		var key string
-		var ok bool
-		newoffset, _key := Decode(input, offset)
-		if key, ok = _key.(string); !ok {
-			log.Panicf("have key we don't understand: %v, byte is %v, offset is %v", _key, (*input)[offset], offset)
-			return value, 0
+		check.element()
+		key, consumed := dec.parseMapKey(input, offset)
+		if dec.DuplicateKeys != LastWins {
+			if _, found := value[key]; found {
+				if dec.DuplicateKeys == ErrorOnDuplicate {
+					panic(&DuplicateKeyError{Key: key, Offset: offset})
+				}
+				newoffset, _value := dec.decode(input, offset+consumed, depth, check)
+				Release(_value)
+				offset = newoffset
+				continue
+			}
 		}
-		offset = newoffset
-		newoffset, _value := Decode(input, offset)
+		offset += consumed
+		newoffset, _value := dec.decode(input, offset, depth, check)
 		value[key] = _value
 		offset = newoffset
 	}
 	return value, offset - initialoffset
 }
 
-func parseArray(input *[]byte, offset int) ([]interface{}, int) {
+func (dec *Decoder) parseArray(input *[]byte, offset int, depth int, check *cancelCheck) ([]interface{}, int) {
 	var (
 		value  []interface{}
 		length int
@@ -181,16 +230,88 @@ func parseArray(input *[]byte, offset int) ([]interface{}, int) {
 		length = int(getUint(input, offset+1, 4))
 		offset += 5
 	}
-	value = make([]interface{}, length, length)
+	value = getNewArray(length)
 	for arridx := 0; arridx < length; arridx++ {
-		newoffset, _val := Decode(input, offset)
+		check.element()
+		newoffset, _val := dec.decode(input, offset, depth, check)
 		offset = newoffset
 		value[arridx] = _val
 	}
 	return value, offset - initialoffset
 }
 
+// Decodes the msgpack object that starts at @offset in @input. Returns the
+// offset just past the end of that object along with the decoded value.
+// Decode keeps no state between calls and is safe for concurrent use.
+//
+// Decode never panics. If the object is malformed or cut short, or holds
+// something Decode can't represent (ext objects, map keys that aren't
+// strings, or nesting deeper than DEFAULT_MAX_DEPTH), it returns @offset
+// unchanged and nil; a real object always takes up at least one byte. Use
+// TryDecode to find out what is wrong.
 func Decode(input *[]byte, offset int) (int, interface{}) {
+	return defaultDecoder.Decode(input, offset)
+}
+
+// Like Decode, but when the object can't be decoded also returns why: a
+// *DecodeError, or with ErrorOnDuplicate a *DuplicateKeyError.
+func TryDecode(input *[]byte, offset int) (int, interface{}, error) {
+	return defaultDecoder.TryDecode(input, offset)
+}
+
+// Decodes the msgpack object that starts at @offset in @input, interning
+// strings and handling duplicate map keys and invalid UTF-8 as configured.
+// Returns the same values as the package-level Decode, which are @offset
+// and nil if the input can't be decoded, including when ErrorOnDuplicate
+// finds a repeated key or RejectInvalidUTF8 a bad str. TryDecode returns
+// the error instead.
+func (dec *Decoder) Decode(input *[]byte, offset int) (int, interface{}) {
+	newoffset, value, _ := dec.tryDecode(input, offset, 0, nil)
+	return newoffset, value
+}
+
+// Like the package-level TryDecode, but with this Decoder's settings
+func (dec *Decoder) TryDecode(input *[]byte, offset int) (int, interface{}, error) {
+	return dec.tryDecode(input, offset, 0, nil)
+}
+
+// Decode, but returning the reason the object couldn't be decoded. @depth
+// is how many arrays and maps the object is inside of, and @check, if not
+// nil, stops decoding once its context is done
+func (dec *Decoder) tryDecode(input *[]byte, offset int, depth int, check *cancelCheck) (newoffset int, value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// decode panics with these on bad input; anything else is a bug
+			switch failure := r.(type) {
+			case *DecodeError:
+				err = failure
+			case *DuplicateKeyError:
+				err = failure
+			case canceled:
+				err = failure.err
+			default:
+				panic(r)
+			}
+			newoffset, value = offset, nil
+		}
+	}()
+	newoffset, value = dec.decode(input, offset, depth, check)
+	return newoffset, value, nil
+}
+
+// Does the work of Decode, panicking with a *DecodeError or
+// *DuplicateKeyError if it can't
+func (dec *Decoder) decode(input *[]byte, offset int, depth int, check *cancelCheck) (int, interface{}) {
+	// this checks that the object's header and, for everything but arrays
+	// and maps, its contents are all there, so the parse functions below
+	// can index without checking
+	h, err := ReadHeader(*input, offset)
+	if err != nil {
+		panic(err)
+	}
+	if (h.Type == ArrayType || h.Type == MapType) && depth >= dec.maxDepth() {
+		panic(tooDeepError(offset))
+	}
 	c := (*input)[offset]
 	var (
 		value    interface{} // the decoded value
@@ -223,19 +344,23 @@ func Decode(input *[]byte, offset int) (int, interface{}) {
 		0xd9 == c, //str8
 		0xda == c, //str16
 		0xdb == c: //str32
-		value, consumed = parseString(input, offset)
+		value, consumed = dec.parseStr(input, offset)
 
-	// map[string]interface{}
+	// map[string]interface{} or OrderedMap
 	case 0x80 <= c && c <= 0x8f, //fixmap
 		0xde == c, //map 16
 		0xdf == c: //map 32
-		value, consumed = parseMap(input, offset)
+		if dec.OrderedMaps {
+			value, consumed = dec.parseOrderedMap(input, offset, depth+1, check)
+		} else {
+			value, consumed = dec.parseMap(input, offset, depth+1, check)
+		}
 
 	// array []interface{}
 	case 0x90 <= c && c <= 0x9f, //fixarray
 		0xdc == c, //array 16
 		0xdd == c: //array 32
-		value, consumed = parseArray(input, offset)
+		value, consumed = dec.parseArray(input, offset, depth+1, check)
 
 	case 0xc0 == c: //nil
 		value, consumed = nil, 1
@@ -244,12 +369,11 @@ func Decode(input *[]byte, offset int) (int, interface{}) {
 	case 0xc3 == c: //true
 		value, consumed = true, 1
 
-	case 0xc4 == c: //bin8
-		fallthrough
-	case 0xc5 == c: //bin16
-		fallthrough
-	case 0xc6 == c: //bin32
-		fallthrough
+	// []byte
+	case 0xc4 == c, //bin8
+		0xc5 == c, //bin16
+		0xc6 == c: //bin32
+		value, consumed = parseBin(input, offset)
 
 	case 0xc7 == c: //ext8
 		fallthrough
@@ -270,7 +394,7 @@ func Decode(input *[]byte, offset int) (int, interface{}) {
 		fallthrough
 
 	default:
-		log.Panicf("Unrecognized msgpack byte %v", c)
+		panic(&DecodeError{Offset: offset, Msg: fmt.Sprintf("cannot decode %v", h)})
 	}
 	offset += consumed
 	return offset, value
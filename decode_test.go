@@ -126,3 +126,41 @@ func BenchmarkDecodeFixMap(b *testing.B) {
 func BenchmarkDecodeMap16(b *testing.B) {
 	b.SkipNow() // needs implementation
 }
+
+func TestDecodeRelease(t *testing.T) {
+	//{'a':[1,2],'b':{'c':3}}
+	bytes := []byte{0x82, 0xa1, 0x61, 0x92, 0x1, 0x2, 0xa1, 0x62, 0x81, 0xa1, 0x63, 0x3}
+	for i := 0; i < 3; i++ {
+		_, dec := Decode(&bytes, 0)
+		m := dec.(map[string]interface{})
+		if len(m) != 2 {
+			t.Errorf("Decoded map should have 2 keys but has %v", m)
+		}
+		arr := m["a"].([]interface{})
+		if len(arr) != 2 || arr[0].(int64) != 1 || arr[1].(int64) != 2 {
+			t.Errorf("Decoded array should be [1 2] but was %v", arr)
+		}
+		inner := m["b"].(map[string]interface{})
+		if len(inner) != 1 || inner["c"].(int64) != 3 {
+			t.Errorf("Decoded map should be map[c:3] but was %v", inner)
+		}
+		Release(dec)
+	}
+
+	// a released map must come back empty
+	Release(map[string]interface{}{"stale": 1})
+	bytes = []byte{0x80}
+	_, dec := Decode(&bytes, 0)
+	if len(dec.(map[string]interface{})) != 0 {
+		t.Errorf("Decoded map should be empty but was %v", dec)
+	}
+}
+
+func BenchmarkDecodeFixMapRelease(b *testing.B) {
+	//{'a':0,'b':1,'c':2,'d':3,'e':4,'f':5,'g':6,'h':7,'i':8,'j':9,'k':10,'l':11,'m':12,'n':13,'o':14}
+	bytes := []byte{0x8f, 0xa1, 0x61, 0x0, 0xa1, 0x63, 0x2, 0xa1, 0x62, 0x1, 0xa1, 0x65, 0x4, 0xa1, 0x64, 0x3, 0xa1, 0x67, 0x6, 0xa1, 0x66, 0x5, 0xa1, 0x69, 0x8, 0xa1, 0x68, 0x7, 0xa1, 0x6b, 0xa, 0xa1, 0x6a, 0x9, 0xa1, 0x6d, 0xc, 0xa1, 0x6c, 0xb, 0xa1, 0x6f, 0xe, 0xa1, 0x6e, 0xd}
+	for i := 0; i < b.N; i++ {
+		_, dec := Decode(&bytes, 0)
+		Release(dec)
+	}
+}
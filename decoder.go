@@ -0,0 +1,167 @@
+package msgpack
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const DEFAULT_MAX_INTERNED = 4096
+
+// arrays and maps nested deeper than this are refused, so that hostile
+// input can't use up the stack
+const DEFAULT_MAX_DEPTH = 10000
+
+// What a Decoder does when a map has the same key more than once
+type DuplicateKeyPolicy int
+
+const (
+	// the last value for the key is kept
+	LastWins DuplicateKeyPolicy = iota
+	// the first value for the key is kept and the rest are ignored
+	FirstWins
+	// the map is rejected with a *DuplicateKeyError
+	ErrorOnDuplicate
+)
+
+// What a Decoder does with a str that isn't valid UTF-8, and an Encoder with
+// such a Go string
+type UTF8Policy int
+
+const (
+	// strings are passed through as they are
+	AllowInvalidUTF8 UTF8Policy = iota
+	// the Decoder fails with a *DecodeError, the Encoder with ErrInvalidUTF8
+	RejectInvalidUTF8
+	// the string is decoded as a []byte, or encoded as bin. A map key can't
+	// be a []byte, so the Decoder still rejects invalid keys
+	InvalidUTF8AsBin
+)
+
+// A DuplicateKeyError reports a map key seen twice when the Decoder's
+// DuplicateKeys policy is ErrorOnDuplicate
+type DuplicateKeyError struct {
+	// the key, or for keys that aren't strings, its encoding in hex
+	Key string
+	// where the second copy of the key starts
+	Offset int
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("msgpack: duplicate map key %q at offset %d", e.Key, e.Offset)
+}
+
+// A Decoder decodes msgpack just like Decode does, but can remember the
+// strings it has already seen so that repeated map keys (and, optionally,
+// short string values) come back as the same Go string instead of a fresh
+// allocation each time. This pays off when decoding many messages that
+// share a layout, e.g. telemetry. A Decoder is not safe for concurrent use;
+// keep one per goroutine. The exception is one that interns nothing
+// (InternKeys off and InternValueLength 0), which never writes to itself
+// and can be shared.
+type Decoder struct {
+	// intern map keys
+	InternKeys bool
+	// intern string values no longer than this many bytes. 0 disables
+	// interning of values
+	InternValueLength int
+	// the intern table stops growing once it holds this many strings.
+	// Strings that don't fit are still decoded, just not remembered
+	MaxInterned int
+	// what to do with repeated map keys. Keys are the same if they are
+	// strings with the same contents, or other objects with the same
+	// encoding. Struct fields count as the same key however they are
+	// matched, so "Name" and "name" are duplicates when unmarshaling into
+	// a struct
+	DuplicateKeys DuplicateKeyPolicy
+	// what to do with str objects that aren't valid UTF-8
+	InvalidUTF8 UTF8Policy
+	// decode maps as OrderedMap instead of map[string]interface{}
+	OrderedMaps bool
+	// when unmarshaling a map into a struct, fail with a *DecodeError on a
+	// key that matches no field, unless the struct has a remain field to
+	// collect it. Extra array elements are still ignored
+	DisallowUnknownFields bool
+	// how deeply arrays and maps may be nested. 0 means DEFAULT_MAX_DEPTH
+	MaxDepth int
+
+	interned map[string]string
+}
+
+// the decoder behind the package-level Decode, shared by every call as
+// Decoder allows
+var defaultDecoder = &Decoder{}
+
+// Returns a Decoder that interns map keys, up to DEFAULT_MAX_INTERNED of them
+func NewDecoder() *Decoder {
+	return &Decoder{
+		InternKeys:  true,
+		MaxInterned: DEFAULT_MAX_INTERNED,
+	}
+}
+
+// Forgets all interned strings
+func (dec *Decoder) Reset() {
+	dec.interned = nil
+}
+
+// Returns the interned copy of @raw, adding it to the table if there is room
+func (dec *Decoder) intern(raw []byte) string {
+	// the compiler does not allocate for string(raw) in a map index
+	if s, found := dec.interned[string(raw)]; found {
+		return s
+	}
+	s := string(raw)
+	if len(dec.interned) < dec.MaxInterned {
+		if dec.interned == nil {
+			dec.interned = make(map[string]string)
+		}
+		dec.interned[s] = s
+	}
+	return s
+}
+
+func (dec *Decoder) parseKey(input *[]byte, offset int) (string, int) {
+	if !dec.InternKeys {
+		return parseString(input, offset)
+	}
+	raw, consumed := stringBytes(input, offset)
+	return dec.intern(raw), consumed
+}
+
+func (dec *Decoder) parseValueString(input *[]byte, offset int) (string, int) {
+	raw, consumed := stringBytes(input, offset)
+	if dec.InternValueLength == 0 || len(raw) > dec.InternValueLength {
+		return string(raw), consumed
+	}
+	return dec.intern(raw), consumed
+}
+
+func (dec *Decoder) maxDepth() int {
+	if dec.MaxDepth > 0 {
+		return dec.MaxDepth
+	}
+	return DEFAULT_MAX_DEPTH
+}
+
+func tooDeepError(offset int) error {
+	return &DecodeError{Offset: offset, Msg: "arrays and maps nested too deeply"}
+}
+
+func invalidUTF8Error(offset int) error {
+	return &DecodeError{Offset: offset, Msg: "invalid UTF-8 in str"}
+}
+
+// Decodes the str at @offset as a string, or if it isn't valid UTF-8 and
+// that matters, as a []byte or by panicking
+func (dec *Decoder) parseStr(input *[]byte, offset int) (interface{}, int) {
+	if dec.InvalidUTF8 != AllowInvalidUTF8 {
+		raw, consumed := stringBytes(input, offset)
+		if !utf8.Valid(raw) {
+			if dec.InvalidUTF8 == RejectInvalidUTF8 {
+				panic(invalidUTF8Error(offset))
+			}
+			return append([]byte{}, raw...), consumed
+		}
+	}
+	return dec.parseValueString(input, offset)
+}
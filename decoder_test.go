@@ -0,0 +1,148 @@
+package msgpack
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func sameString(x, y string) bool {
+	return unsafe.StringData(x) == unsafe.StringData(y)
+}
+
+func TestDecoderInternKeys(t *testing.T) {
+	//{'temp':1,'unit':'C'}
+	bytes := []byte{0x82, 0xa4, 0x74, 0x65, 0x6d, 0x70, 0x1, 0xa4, 0x75, 0x6e, 0x69, 0x74, 0xa1, 0x43}
+	dec := NewDecoder()
+	_, first := dec.Decode(&bytes, 0)
+	_, second := dec.Decode(&bytes, 0)
+	var firstkeys, secondkeys []string
+	for k := range first.(map[string]interface{}) {
+		firstkeys = append(firstkeys, k)
+	}
+	for k := range second.(map[string]interface{}) {
+		secondkeys = append(secondkeys, k)
+	}
+	if len(firstkeys) != 2 || len(secondkeys) != 2 {
+		t.Fatalf("Decoded maps should have 2 keys but were %v and %v", first, second)
+	}
+	for _, k := range firstkeys {
+		found := false
+		for _, k2 := range secondkeys {
+			if k == k2 && sameString(k, k2) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Key %v should have been interned", k)
+		}
+	}
+	if second.(map[string]interface{})["unit"].(string) != "C" {
+		t.Errorf("Decode should be C but was %v", second.(map[string]interface{})["unit"])
+	}
+}
+
+func TestDecoderInternValues(t *testing.T) {
+	bytes := []byte{0x92, 0xa2, 0x6f, 0x6b, 0xa2, 0x6f, 0x6b} // ['ok', 'ok']
+	dec := NewDecoder()
+	_, val := dec.Decode(&bytes, 0)
+	arr := val.([]interface{})
+	if sameString(arr[0].(string), arr[1].(string)) {
+		t.Errorf("Values should not be interned by default")
+	}
+
+	dec.InternValueLength = 2
+	_, val = dec.Decode(&bytes, 0)
+	arr = val.([]interface{})
+	if arr[0].(string) != "ok" || !sameString(arr[0].(string), arr[1].(string)) {
+		t.Errorf("Values should have been interned but were %v", arr)
+	}
+}
+
+func TestDecoderMaxInterned(t *testing.T) {
+	bytes := []byte{0x82, 0xa1, 0x61, 0x1, 0xa1, 0x62, 0x2} // {'a':1,'b':2}
+	dec := NewDecoder()
+	dec.MaxInterned = 1
+	_, val := dec.Decode(&bytes, 0)
+	if len(dec.interned) != 1 {
+		t.Errorf("Intern table should hold 1 string but holds %v", dec.interned)
+	}
+	if len(val.(map[string]interface{})) != 2 {
+		t.Errorf("Decoded map should have 2 keys but was %v", val)
+	}
+	dec.Reset()
+	if len(dec.interned) != 0 {
+		t.Errorf("Intern table should be empty after Reset but holds %v", dec.interned)
+	}
+}
+
+func BenchmarkDecoderFixMap(b *testing.B) {
+	//{'a':0,'b':1,'c':2,'d':3,'e':4,'f':5,'g':6,'h':7,'i':8,'j':9,'k':10,'l':11,'m':12,'n':13,'o':14}
+	bytes := []byte{0x8f, 0xa1, 0x61, 0x0, 0xa1, 0x63, 0x2, 0xa1, 0x62, 0x1, 0xa1, 0x65, 0x4, 0xa1, 0x64, 0x3, 0xa1, 0x67, 0x6, 0xa1, 0x66, 0x5, 0xa1, 0x69, 0x8, 0xa1, 0x68, 0x7, 0xa1, 0x6b, 0xa, 0xa1, 0x6a, 0x9, 0xa1, 0x6d, 0xc, 0xa1, 0x6c, 0xb, 0xa1, 0x6f, 0xe, 0xa1, 0x6e, 0xd}
+	dec := NewDecoder()
+	for i := 0; i < b.N; i++ {
+		_, val := dec.Decode(&bytes, 0)
+		Release(val)
+	}
+}
+
+func TestDecoderDuplicateKeys(t *testing.T) {
+	//{'a':1,'b':2,'a':3} with the second 'a' as a str8
+	bytes := []byte{0x83, 0xa1, 0x61, 0x1, 0xa1, 0x62, 0x2, 0xd9, 0x1, 0x61, 0x3}
+	for policy, want := range map[DuplicateKeyPolicy]int64{LastWins: 3, FirstWins: 1} {
+		dec := &Decoder{DuplicateKeys: policy}
+		offset, value := dec.Decode(&bytes, 0)
+		m := value.(map[string]interface{})
+		if offset != len(bytes) || len(m) != 2 || m["a"] != want || m["b"] != int64(2) {
+			t.Errorf("policy %v decoded %v ending at %d, want a=%d", policy, m, offset, want)
+		}
+	}
+
+	dec := &Decoder{DuplicateKeys: ErrorOnDuplicate}
+	if offset, value := dec.Decode(&bytes, 0); offset != 0 || value != nil {
+		t.Errorf("Decode should fail on the duplicate key, not return %v ending at %d", value, offset)
+	}
+	offset, value, err := dec.TryDecode(&bytes, 0)
+	if dup, ok := err.(*DuplicateKeyError); !ok || dup.Key != "a" || dup.Offset != 7 || offset != 0 || value != nil {
+		t.Errorf("TryDecode should return a duplicate key error for 'a' at 7, not %v, %v ending at %d", err, value, offset)
+	}
+	var any interface{}
+	if err, ok := dec.Unmarshal(bytes, &any).(*DuplicateKeyError); !ok || err.Key != "a" || err.Offset != 7 {
+		t.Errorf("Unmarshal should return a duplicate key error for 'a' at 7, not %v", err)
+	}
+}
+
+func TestDecoderInvalidUTF8(t *testing.T) {
+	//['ok', 'a\xff']
+	bytes := []byte{0x92, 0xa2, 0x6f, 0x6b, 0xa2, 0x61, 0xff}
+	_, value := (&Decoder{}).Decode(&bytes, 0)
+	if value.([]interface{})[1] != "a\xff" {
+		t.Errorf("AllowInvalidUTF8 should pass the string through, not %v", value)
+	}
+	_, value = (&Decoder{InvalidUTF8: InvalidUTF8AsBin}).Decode(&bytes, 0)
+	arr := value.([]interface{})
+	if arr[0] != "ok" || string(arr[1].([]byte)) != "a\xff" {
+		t.Errorf("InvalidUTF8AsBin should decode the bad string as []byte, not %#v", arr)
+	}
+
+	dec := &Decoder{InvalidUTF8: RejectInvalidUTF8}
+	if offset, value := dec.Decode(&bytes, 0); offset != 0 || value != nil {
+		t.Errorf("Decode should fail on the bad string, not return %v ending at %d", value, offset)
+	}
+	var any interface{}
+	if err, ok := dec.Unmarshal(bytes, &any).(*DecodeError); !ok || err.Offset != 4 {
+		t.Errorf("Unmarshal should return a DecodeError at 4, not %v", err)
+	}
+}
+
+func TestDecodeBin(t *testing.T) {
+	bytes := []byte{0x92, 0xc4, 0x2, 0x1, 0x2, 0xc5, 0x0, 0x1, 0xff}
+	offset, value := Decode(&bytes, 0)
+	arr := value.([]interface{})
+	if offset != len(bytes) || string(arr[0].([]byte)) != "\x01\x02" || string(arr[1].([]byte)) != "\xff" {
+		t.Errorf("Decoded %#v ending at %d", value, offset)
+	}
+	bytes[3] = 0
+	if arr[0].([]byte)[0] != 1 {
+		t.Errorf("Decoded bin should not share memory with the input")
+	}
+}
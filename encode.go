@@ -8,39 +8,23 @@
 // to figure out. The focus of this package is not to provide the most fully featured
 // MsgPack implementation out there, but rather support the main data types that I
 // use in my work (most of the basic types, also []interface{} and map[string]interface{}).
-// You'll notice that I don't use the `reflect` package at all, and that's because I
-// wanted to see what code looked like when you just did a switch on types instead of
-// using runtime reflection to figure out the types. This of course comes at the cost
-// of not being able to extend this MsgPack implementation to arbitrary data types.
-// Implementing a new type on your own is not difficult, though.
+// Trees of those basic types are encoded and decoded with a plain switch on types
+// instead of the `reflect` package, because I wanted to see what code looked like
+// without runtime reflection, and that fast path stays allocation-light. Typed values
+// (structs, pointers, and typed slices and maps) do go through `reflect`; see
+// marshal.go and unmarshal.go.
 //
 // Coming up next are some more convenience methods for doing decoding and encoding
 // through writer/reader/buffer interfaces
 package msgpack
 
 import (
-	"fmt"
 	"gopkg.in/vmihailenco/msgpack.v2"
 	"math"
-	"sync"
+	"reflect"
+	"unicode/utf8"
 )
 
-const DEFAULT_ARR_SIZE = 15
-
-var arrpool = sync.Pool{
-	New: func() interface{} {
-		return make([]interface{}, DEFAULT_ARR_SIZE)
-	},
-}
-
-func getNewArray(length int) []interface{} {
-	if length <= 15 {
-		return arrpool.Get().([]interface{})[:length]
-	} else {
-		return make([]interface{}, length)
-	}
-}
-
 /** Each of these functions should take 3 arguments: the buffer to add into, an
  * offset into that buffer (where our writes start), and the value to encode.
  * After encoding the value and placing the byte sequence in the buffer
@@ -63,11 +47,8 @@ func encodeBool(buf []byte, offset int, val bool) int {
 }
 
 func encodeInt(buf []byte, offset int, val int64) int {
-	if val < 128 && val >= 0 {
-		buf[offset] = byte(0x7f & val)
-		offset += 1
-	} else if val < 0 && val > -32 {
-		buf[offset] = byte(0xe0 | (0x1f & -val))
+	if val < 128 && val >= -32 { // positive and negative fixint
+		buf[offset] = byte(val)
 		offset += 1
 	} else { // go to int64
 		// find the smallest mask we can use
@@ -90,17 +71,15 @@ func encodeInt(buf []byte, offset int, val int64) int {
 			buf[offset+3] = byte(uint64(val) >> 8)
 			buf[offset+4] = byte(uint64(val))
 			offset += 5
-		case val < -128 || val > 128:
+		case val < -128 || val >= 128:
 			buf[offset] = byte(0xd1)
 			buf[offset+1] = byte(uint64(val) >> 8)
 			buf[offset+2] = byte(uint64(val))
 			offset += 3
-		case val < -32:
+		default: // -128 up to -33
 			buf[offset] = byte(0xd0)
 			buf[offset+1] = byte(uint64(val))
 			offset += 2
-		default:
-			println("default")
 		}
 	}
 	return offset
@@ -221,8 +200,67 @@ func encodeString(buf []byte, offset int, val string) int {
 	return offset
 }
 
-func encodeArray(buf []byte, offset int, val []interface{}) int {
+func encodeBin(buf []byte, offset int, val []byte) int {
+	l := len(val)
+	switch {
+	case l <= 255: // bin8
+		buf[offset] = byte(0xc4)
+		buf[offset+1] = byte(l)
+		offset += 2
+	case l <= 65535: // bin16
+		buf[offset] = byte(0xc5)
+		offset += 1
+		offset = encodeLength(buf, offset, uint(l), 2)
+	default: // bin32
+		buf[offset] = byte(0xc6)
+		offset += 1
+		offset = encodeLength(buf, offset, uint(l), 4)
+	}
+	offset += copy(buf[offset:], val)
+	return offset
+}
+
+func encodeExt(buf []byte, offset int, exttype int8, val []byte) int {
 	l := len(val)
+	switch {
+	case l == 1: // fixext 1
+		buf[offset] = byte(0xd4)
+		offset += 1
+	case l == 2: // fixext 2
+		buf[offset] = byte(0xd5)
+		offset += 1
+	case l == 4: // fixext 4
+		buf[offset] = byte(0xd6)
+		offset += 1
+	case l == 8: // fixext 8
+		buf[offset] = byte(0xd7)
+		offset += 1
+	case l == 16: // fixext 16
+		buf[offset] = byte(0xd8)
+		offset += 1
+	case l <= 255: // ext8
+		buf[offset] = byte(0xc7)
+		buf[offset+1] = byte(l)
+		offset += 2
+	case l <= 65535: // ext16
+		buf[offset] = byte(0xc8)
+		offset += 1
+		offset = encodeLength(buf, offset, uint(l), 2)
+	default: // ext32
+		buf[offset] = byte(0xc9)
+		offset += 1
+		offset = encodeLength(buf, offset, uint(l), 4)
+	}
+	buf[offset] = byte(exttype)
+	offset += 1
+	offset += copy(buf[offset:], val)
+	return offset
+}
+
+// the most bytes an array or map header can take
+const maxContainerHeader = 5
+
+func encodeArrayHeader(buf []byte, offset int, l int) int {
 	switch {
 	case l <= 15:
 		buf[offset] = byte(0x90 | l)
@@ -236,14 +274,19 @@ func encodeArray(buf []byte, offset int, val []interface{}) int {
 		offset += 1
 		offset = encodeLength(buf, offset, uint(l), 4)
 	}
+	return offset
+}
+
+func (enc *Encoder) encodeArray(ret *[]byte, offset int, val []interface{}) int {
+	l := len(val)
+	offset = encodeArrayHeader(*ret, offset, l)
 	for i := 0; i < l; i++ {
-		offset = doEncode(val[i], &buf, offset)
+		offset = enc.doEncode(val[i], ret, offset)
 	}
 	return offset
 }
 
-func encodeMap(buf []byte, offset int, val map[string]interface{}) int {
-	l := len(val)
+func encodeMapHeader(buf []byte, offset int, l int) int {
 	switch {
 	case l <= 15:
 		buf[offset] = byte(0x80 | l)
@@ -257,14 +300,45 @@ func encodeMap(buf []byte, offset int, val map[string]interface{}) int {
 		offset += 1
 		offset = encodeLength(buf, offset, uint(l), 4)
 	}
+	return offset
+}
+
+func (enc *Encoder) encodeMap(ret *[]byte, offset int, val map[string]interface{}) int {
+	offset = encodeMapHeader(*ret, offset, len(val))
 	for k, v := range val {
-		offset = doEncode(k, &buf, offset)
-		offset = doEncode(v, &buf, offset)
+		offset = enc.doEncode(k, ret, offset)
+		offset = enc.doEncode(v, ret, offset)
 	}
 	return offset
 }
 
-func doEncode(input interface{}, ret *[]byte, offset int) int {
+// For arrays and maps whose length isn't known until their elements have
+// been written. The caller sets aside maxContainerHeader bytes at @start,
+// writes the elements after them up to @end, then calls this with the
+// element count to fill in the header. The smallest header that fits is
+// used and the elements are moved down against it. Returns the new end.
+func backpatchHeader(buf []byte, start, end, count int, ismap bool) int {
+	var headerlen int
+	if ismap {
+		headerlen = encodeMapHeader(buf[start:start+maxContainerHeader], 0, count)
+	} else {
+		headerlen = encodeArrayHeader(buf[start:start+maxContainerHeader], 0, count)
+	}
+	if headerlen < maxContainerHeader {
+		copy(buf[start+headerlen:], buf[start+maxContainerHeader:end])
+	}
+	return end - (maxContainerHeader - headerlen)
+}
+
+// Encodes a Go string, or with InvalidUTF8AsBin, a bin if it isn't UTF-8
+func (enc *Encoder) encodeString(buf []byte, offset int, val string) int {
+	if enc.InvalidUTF8 == InvalidUTF8AsBin && !utf8.ValidString(val) {
+		return encodeBin(buf, offset, []byte(val))
+	}
+	return encodeString(buf, offset, val)
+}
+
+func (enc *Encoder) doEncode(input interface{}, ret *[]byte, offset int) int {
 	switch input.(type) {
 	case int:
 		offset = encodeInt(*ret, offset, int64(input.(int)))
@@ -291,36 +365,165 @@ func doEncode(input interface{}, ret *[]byte, offset int) int {
 	case float64:
 		offset = encodeFloat64(*ret, offset, input.(float64))
 	case string:
-		offset = encodeString(*ret, offset, input.(string))
+		offset = enc.encodeString(*ret, offset, input.(string))
+	case []byte:
+		offset = encodeBin(*ret, offset, input.([]byte))
+	case Raw:
+		offset = encodeRaw(*ret, offset, input.(Raw))
 	case map[string]interface{}:
-		offset = encodeMap(*ret, offset, input.(map[string]interface{}))
+		offset = enc.encodeMap(ret, offset, input.(map[string]interface{}))
 	case []interface{}:
-		offset = encodeArray(*ret, offset, input.([]interface{}))
+		offset = enc.encodeArray(ret, offset, input.([]interface{}))
+	case OrderedMap:
+		offset = enc.encodeOrderedMap(ret, offset, input.(OrderedMap))
 	case bool:
 		offset = encodeBool(*ret, offset, input.(bool))
 	case nil:
 		offset = encodeNil(*ret, offset)
-	case interface{}:
-		offset = doEncodeReflect(input, ret, offset)
 	default:
-		offset = doEncodeReflect(input, ret, offset)
+		offset = enc.encodeValue(ret, offset, reflect.ValueOf(input))
 	}
 	return offset
 }
 
-func doEncodeReflect(input interface{}, ret *[]byte, offset int) int {
+// what the encode functions panic with when vmihailenco can't encode a
+// value, or Encode is given a type that can't be encoded
+type encodeFailure struct {
+	err error
+}
+
+// Encodes @input, which encodes itself, with vmihailenco. maxEncodedSize
+// doesn't count these, since it would have to encode them to know their
+// size, so *@ret is lengthened by however many bytes it takes
+func encodeSelf(ret *[]byte, offset int, input interface{}) int {
 	b, err := msgpack.Marshal(input)
 	if err != nil {
-		fmt.Errorf("Error %v\n", err)
+		panic(encodeFailure{err})
+	}
+	need := len(*ret) + len(b)
+	if need > cap(*ret) {
+		grown := make([]byte, need, 2*need)
+		copy(grown, (*ret)[:offset])
+		*ret = grown
+	}
+	*ret = (*ret)[:need]
+	return offset + copy((*ret)[offset:], b)
+}
+
+// doEncode, but returning the error encodeFailure panics with, and @offset
+func (enc *Encoder) tryEncode(input interface{}, ret *[]byte, offset int) (newoffset int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure, ok := r.(encodeFailure)
+			if !ok {
+				panic(r)
+			}
+			newoffset, err = offset, failure.err
+		}
+	}()
+	return enc.doEncode(input, ret, offset), nil
+}
+
+// Returns an upper bound on the number of bytes doEncode will use for
+// @input. Fixed-size values count as their widest encoding. The error is
+// for a value that can't be encoded, or ErrInvalidUTF8 for a string that is
+// refused
+func (enc *Encoder) maxEncodedSize(input interface{}) (int, error) {
+	switch input := input.(type) {
+	case nil, bool:
+		return 1, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return 9, nil
+	case string:
+		if enc.InvalidUTF8 == RejectInvalidUTF8 && !utf8.ValidString(input) {
+			return 0, ErrInvalidUTF8
+		}
+		return 5 + len(input), nil
+	case []byte:
+		return 5 + len(input), nil
+	case Raw:
+		return 1 + len(input), nil
+	case map[string]interface{}:
+		size := maxContainerHeader
+		for k, v := range input {
+			if enc.InvalidUTF8 == RejectInvalidUTF8 && !utf8.ValidString(k) {
+				return 0, ErrInvalidUTF8
+			}
+			vsize, err := enc.maxEncodedSize(v)
+			if err != nil {
+				return 0, err
+			}
+			size += 5 + len(k) + vsize
+		}
+		return size, nil
+	case OrderedMap:
+		return enc.maxOrderedMapSize(input)
+	case []interface{}:
+		size := maxContainerHeader
+		for _, v := range input {
+			vsize, err := enc.maxEncodedSize(v)
+			if err != nil {
+				return 0, err
+			}
+			size += vsize
+		}
+		return size, nil
+	default:
+		return enc.maxValueSize(reflect.ValueOf(input))
+	}
+}
+
+// Appends the msgpack encoding of @input to @dst, growing it if there is not
+// enough room, and returns the extended slice. Unlike Encode, the caller
+// does not need to know how big the encoding will be ahead of time. Returns
+// @dst unchanged and the same error Marshal would if @input can't be
+// encoded.
+func Append(dst []byte, input interface{}) ([]byte, error) {
+	need, err := defaultEncoder.maxEncodedSize(input)
+	if err != nil {
+		return dst, err
+	}
+	return defaultEncoder.appendEncoded(dst, input, need)
+}
+
+func (enc *Encoder) appendEncoded(dst []byte, input interface{}, need int) ([]byte, error) {
+	if cap(dst)-len(dst) < need {
+		grown := make([]byte, len(dst), 2*cap(dst)+need)
+		copy(grown, dst)
+		dst = grown
+	}
+	buf := dst[:cap(dst)]
+	offset, err := enc.tryEncode(input, &buf, len(dst))
+	if err != nil {
+		return dst, err
+	}
+	return buf[:offset], nil
+}
+
+// Returns the msgpack encoding of @input in a new slice. Besides the types
+// Encode switches on, structs, pointers, and typed slices and maps are
+// encoded by walking them with reflect. Types that can't be encoded, such
+// as channels, cause an error.
+func Marshal(input interface{}) ([]byte, error) {
+	return defaultEncoder.Marshal(input)
+}
+
+// Like the package-level Marshal, but with this Encoder's settings. Nothing
+// is written to the Encoder's writer
+func (enc *Encoder) Marshal(input interface{}) ([]byte, error) {
+	need, err := enc.maxEncodedSize(input)
+	if err != nil {
+		return nil, err
 	}
-	copy((*ret)[offset:], b)
-	return offset + len(b)
+	return enc.appendEncoded(nil, input, need)
 }
 
 // Encodes the input as a msgpack byte array, which is provided
 // by the user. This allows the user to control how many allocations
 // are done. Returns the length of the encoded message, but does
-// not adjust the length of the input array.
-func Encode(input interface{}, ret *[]byte) int {
-	return doEncode(input, ret, 0)
+// not adjust the length of the input array, except to make room for
+// values vmihailenco encodes, such as time.Time. Types that can't be
+// encoded, such as channels, are an error.
+func Encode(input interface{}, ret *[]byte) (int, error) {
+	return defaultEncoder.tryEncode(input, ret, 0)
 }
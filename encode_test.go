@@ -54,7 +54,7 @@ func compareInterfaceInt64Slice(x, y []interface{}) bool {
 func TestEncodeBool(t *testing.T) {
 	val := true
 	bytes := bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	if done != 1 {
 		t.Errorf("Encoded length should be 1 but is %v", len(bytes))
 	}
@@ -65,7 +65,7 @@ func TestEncodeBool(t *testing.T) {
 	bytes = bufpool.Get().([]byte)
 
 	val = false
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 1 {
 		t.Errorf("Encoded length should be 1 but is %v", len(bytes))
 	}
@@ -91,7 +91,7 @@ func TestEncodeFixInt(t *testing.T) {
 	// valid pos fixint
 	val = 120
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	if done != 1 {
 		t.Errorf("Encoded length should be 1 but is %v", len(bytes))
 	}
@@ -107,12 +107,12 @@ func TestEncodeFixInt(t *testing.T) {
 	// valid neg fixint
 	val = -20
 	bytes = bufpool.Get().([]byte)
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 1 {
 		t.Errorf("Encoded length should be 1 but is %v", len(bytes))
 	}
-	if bytes[0] != byte(0xf4) {
-		t.Errorf("Int should be 0xf4 but is 0x%x", bytes[0])
+	if bytes[0] != byte(0xec) {
+		t.Errorf("Int should be 0xec but is 0x%x", bytes[0])
 	}
 	_, dec = Decode(&bytes, 0)
 	if dec.(int64) != -20 {
@@ -136,7 +136,7 @@ func TestEncodeInt(t *testing.T) {
 
 	val = 32123 // int16
 	bytes := bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	if done != 3 {
 		t.Errorf("Encoded length should be 3 but is %v", len(bytes))
 	}
@@ -151,7 +151,7 @@ func TestEncodeInt(t *testing.T) {
 
 	val = -1234 // int16
 	bytes = bufpool.Get().([]byte)
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 3 {
 		t.Errorf("Encoded length should be 3 but is %v", len(bytes))
 	}
@@ -171,7 +171,7 @@ func TestEncodeIntBigger(t *testing.T) {
 
 	val = 2147483647 // int32
 	bytes := bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	if done != 5 {
 		t.Errorf("Encoded length should be 5 but is %v", len(bytes))
 	}
@@ -186,7 +186,7 @@ func TestEncodeIntBigger(t *testing.T) {
 
 	val = 4194957296 // int64
 	bytes = bufpool.Get().([]byte)
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 9 {
 		t.Errorf("Encoded length should be 9 but is %v", len(bytes))
 	}
@@ -234,7 +234,7 @@ func TestEncodeUint(t *testing.T) {
 
 	val = 120 // uint8
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	if done != 2 {
 		t.Errorf("Encoded length should be 2 but is %v", len(bytes))
 	}
@@ -249,7 +249,7 @@ func TestEncodeUint(t *testing.T) {
 
 	val = 32123 // uint16
 	bytes = bufpool.Get().([]byte)
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 3 {
 		t.Errorf("Encoded length should be 3 but is %v", len(bytes))
 	}
@@ -264,7 +264,7 @@ func TestEncodeUint(t *testing.T) {
 
 	val = 2147483647 // uint32
 	bytes = bufpool.Get().([]byte)
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 5 {
 		t.Errorf("Encoded length should be 5 but is %v", len(bytes))
 	}
@@ -279,7 +279,7 @@ func TestEncodeUint(t *testing.T) {
 
 	val = uint(time.Now().UnixNano()) // uint64
 	bytes = bufpool.Get().([]byte)
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 9 {
 		t.Errorf("Encoded length should be 9 but is %v", len(bytes))
 	}
@@ -327,7 +327,7 @@ func TestEncodeString(t *testing.T) {
 
 	val = "asdf" // fixstr
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	if done != 1+len(val) {
 		t.Errorf("Encoded length should be %v but is %v", 1+len(val), len(bytes))
 	}
@@ -342,7 +342,7 @@ func TestEncodeString(t *testing.T) {
 
 	val = "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz" // str8
 	bytes = bufpool.Get().([]byte)
-	done = Encode(val, &bytes)
+	done, _ = Encode(val, &bytes)
 	if done != 2+len(val) {
 		t.Errorf("Encoded length should be %v but is %v", 1+len(val), len(bytes))
 	}
@@ -371,7 +371,7 @@ func TestEncodeFixArray(t *testing.T) {
 
 	val := []interface{}{"asdf", "fdsa", "four", "gabe"}
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	if done != 1+4+4*len(val) { // arr len + 4 strings (1 byte len + 4 string)
 		t.Errorf("Encoded length should be %v but is %v", 1+4+4*len(val), done)
 	}
@@ -393,7 +393,7 @@ func TestEncodeArray16(t *testing.T) {
 
 	val := []interface{}{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p"}
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	length := 3 + 16*2 // arr prefix (3 bytes) + 16 len-1 strings
 	if done != length {
 		t.Errorf("Encoded length should be %v but is %v", length, done)
@@ -424,7 +424,7 @@ func TestEncodeArrayInts(t *testing.T) {
 	var dec interface{}
 	val := []interface{}{int64(1), int64(2), int64(3)}
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	length := 1 + 3*1 // array len + 3 fix-bit numbers
 	if done != length {
 		t.Errorf("Encoded length should be %v but is %v", length, done)
@@ -442,7 +442,7 @@ func TestEncodeFixMap(t *testing.T) {
 
 	val := map[string]interface{}{"a": 1, "b": 2, "c": 3}
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	length := 1 + 3*2 + 3 // 1 byte for map prefix, 3 * fixstring (2 bytes) + 3 * fixint
 	if done != length {
 		t.Errorf("Encoded length should be %v but is %v", length, done)
@@ -466,7 +466,7 @@ func TestEncodeMap16(t *testing.T) {
 
 	val := map[string]interface{}{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7, "h": 8, "i": 9, "j": 10, "k": 11, "l": 12, "m": 13, "n": 14, "o": 15, "p": 16}
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	length := 3 + 16*2 + 16 // 1 byte for map prefix, 16 * fixstring (2 bytes) + 16 * fixint
 	if done != length {
 		t.Errorf("Encoded length should be %v but is %v", length, done)
@@ -507,7 +507,7 @@ func TestEncodeFloat32(t *testing.T) {
 	var dec interface{}
 	var val float32 = 2.5
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	length := 5 // len of float32
 	if done != length {
 		t.Errorf("Encoded length should be %v but is %v", length, done)
@@ -528,7 +528,7 @@ func TestEncodeFloat64(t *testing.T) {
 	var dec interface{}
 	var val float64 = 2000000000000.5
 	bytes = bufpool.Get().([]byte)
-	done := Encode(val, &bytes)
+	done, _ := Encode(val, &bytes)
 	length := 9 // len of float64
 	if done != length {
 		t.Errorf("Encoded length should be %v but is %v", length, done)
@@ -543,3 +543,108 @@ func TestEncodeFloat64(t *testing.T) {
 	}
 	bufpool.Put(bytes)
 }
+
+func TestAppend(t *testing.T) {
+	var dec interface{}
+	val := map[string]interface{}{"a": []interface{}{"asdf", int64(1), true, nil}, "b": 2.5}
+
+	bytes, err := Append(nil, val)
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	_, dec = Decode(&bytes, 0)
+	m := dec.(map[string]interface{})
+	if m["b"].(float64) != 2.5 || !compareInterfaceStringSlice(m["a"].([]interface{})[:1], []interface{}{"asdf"}) {
+		t.Errorf("Decode should be %v but was %v", val, dec)
+	}
+
+	// appending keeps what was already there
+	prefix := []byte{0xc0, 0xc3}
+	bytes, _ = Append(prefix[:2:2], "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz")
+	if bytes[0] != 0xc0 || bytes[1] != 0xc3 || bytes[2] != 0xd9 {
+		t.Errorf("Should be appended as str8 0xd9 after the prefix but was %x", bytes[:3])
+	}
+	if len(bytes) != 2+2+52 {
+		t.Errorf("Encoded length should be %v but is %v", 2+2+52, len(bytes))
+	}
+
+	// what Marshal refuses, Append refuses without touching dst
+	bytes, err = Append(prefix[:2:2], struct {
+		A string
+		C chan int
+	}{})
+	if err == nil || len(bytes) != 2 {
+		t.Errorf("Append of a chan should fail leaving dst alone, returned %x (%v)", bytes, err)
+	}
+}
+
+type benchStruct struct {
+	Name    string  `msgpack:"name"`
+	ID      int64   `msgpack:"id"`
+	Value   float64 `msgpack:"value"`
+	Enabled bool    `msgpack:"enabled"`
+	Unit    string  `msgpack:"unit"`
+}
+
+var benchStructVal = benchStruct{Name: "sensor", ID: 42, Value: 21.5, Enabled: true, Unit: "C"}
+
+var benchMapVal = map[string]interface{}{"name": "sensor", "id": int64(42), "value": 21.5, "enabled": true, "unit": "C"}
+
+func TestEncodeStructMatchesMap(t *testing.T) {
+	fromstruct, _ := Marshal(benchStructVal)
+	_, decoded := Decode(&fromstruct, 0)
+	if len(decoded.(map[string]interface{})) != len(benchMapVal) {
+		t.Fatalf("Struct encoded as %v", decoded)
+	}
+	for k, v := range benchMapVal {
+		if decoded.(map[string]interface{})[k] != v {
+			t.Errorf("Struct encoded %s as %v, want %v", k, decoded.(map[string]interface{})[k], v)
+		}
+	}
+}
+
+func BenchmarkEncodeStruct(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		bytes := bufpool.Get().([]byte)
+		Encode(benchStructVal, &bytes)
+		bufpool.Put(bytes)
+	}
+}
+
+func BenchmarkEncodeStructMap(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		bytes := bufpool.Get().([]byte)
+		Encode(benchMapVal, &bytes)
+		bufpool.Put(bytes)
+	}
+}
+
+func BenchmarkMarshalStruct(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Marshal(benchStructVal)
+	}
+}
+
+func BenchmarkMarshalStructMap(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Marshal(benchMapVal)
+	}
+}
+
+func BenchmarkUnmarshalStruct(b *testing.B) {
+	bytes, _ := Marshal(benchStructVal)
+	dec := NewDecoder()
+	for i := 0; i < b.N; i++ {
+		var val benchStruct
+		dec.Unmarshal(bytes, &val)
+	}
+}
+
+func BenchmarkDecodeStructMap(b *testing.B) {
+	bytes, _ := Marshal(benchMapVal)
+	dec := NewDecoder()
+	for i := 0; i < b.N; i++ {
+		_, val := dec.Decode(&bytes, 0)
+		Release(val)
+	}
+}
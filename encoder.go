@@ -0,0 +1,226 @@
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Passed to BeginArray and BeginMap when the number of elements isn't known
+// yet
+const UnknownLength = -1
+
+// once this many bytes are ready to go, they are written out
+const DEFAULT_ENCODER_BUFFER = 4096
+
+var (
+	errEndTooEarly = errors.New("msgpack: End called before all elements were written")
+	ErrInvalidUTF8 = errors.New("msgpack: string is not valid UTF-8")
+)
+
+// An array or map an Encoder is in the middle of
+type openContainer struct {
+	ismap bool
+	// values still to come, counting map keys and values separately.
+	// -1 if the length is unknown
+	remaining int
+	// for unknown lengths, where the header goes and how many values have
+	// been written so far
+	start   int
+	written int
+}
+
+// An Encoder writes a stream of msgpack values to an io.Writer. Unlike
+// Encode, which needs a whole []interface{} or map[string]interface{} up
+// front, an Encoder can write arrays and maps one element at a time:
+//
+//	enc := msgpack.NewEncoder(w)
+//	enc.BeginArray(msgpack.UnknownLength)
+//	for rows.Next() {
+//		enc.BeginMap(2)
+//		enc.Encode("id")
+//		enc.Encode(id)
+//		enc.Encode("name")
+//		enc.Encode(name)
+//		enc.End()
+//	}
+//	enc.End()
+//	err := enc.Flush()
+//
+// Map keys and values are each written with their own call. When the length
+// is given, the header is written straight away and the bytes can go out as
+// the elements are encoded. With UnknownLength, everything from the start of
+// the container is held in memory until End, which fills in the smallest
+// header that fits the count. An Encoder is not safe for concurrent use.
+type Encoder struct {
+	// what to do with strings that aren't valid UTF-8: write them as str
+	// anyway, refuse them with ErrInvalidUTF8, or write them as bin. Map
+	// keys included
+	InvalidUTF8 UTF8Policy
+	// encode every struct as an array of its fields in order, not just
+	// those tagged asArray
+	StructAsArray bool
+
+	w    io.Writer
+	buf  []byte
+	open []openContainer
+	err  error
+}
+
+// the encoder behind Encode, Append and Marshal. It is only used for its
+// settings, which are all defaults, and never writes anything
+var defaultEncoder = &Encoder{}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encodes @v as the next value. Inside a map, keys and values are both
+// values. The error is sticky: once a write has failed, every later call
+// returns it.
+func (enc *Encoder) Encode(v interface{}) error {
+	need, err := enc.maxEncodedSize(v)
+	if err != nil {
+		return err
+	}
+	if err = enc.element(); err != nil {
+		return err
+	}
+	if enc.buf, err = enc.appendEncoded(enc.buf, v, need); err != nil {
+		return err
+	}
+	return enc.written()
+}
+
+// Starts an array of @n elements, or of however many are written before
+// End if @n is UnknownLength
+func (enc *Encoder) BeginArray(n int) error {
+	return enc.begin(n, false)
+}
+
+// Starts a map of @n key/value pairs, or of however many are written before
+// End if @n is UnknownLength
+func (enc *Encoder) BeginMap(n int) error {
+	return enc.begin(n, true)
+}
+
+func (enc *Encoder) begin(n int, ismap bool) error {
+	if n < 0 && n != UnknownLength {
+		return fmt.Errorf("msgpack: invalid length %d", n)
+	}
+	if err := enc.element(); err != nil {
+		return err
+	}
+	c := openContainer{ismap: ismap, remaining: n}
+	if ismap && n > 0 {
+		c.remaining = 2 * n
+	}
+	enc.grow(maxContainerHeader)
+	switch {
+	case n == UnknownLength:
+		c.start = len(enc.buf)
+		enc.buf = enc.buf[:len(enc.buf)+maxContainerHeader]
+	case ismap:
+		enc.buf = enc.buf[:encodeMapHeader(enc.buf[:cap(enc.buf)], len(enc.buf), n)]
+	default:
+		enc.buf = enc.buf[:encodeArrayHeader(enc.buf[:cap(enc.buf)], len(enc.buf), n)]
+	}
+	enc.open = append(enc.open, c)
+	return enc.finished()
+}
+
+// Finishes the innermost open array or map. If it was given a length, all
+// of its elements must have been written
+func (enc *Encoder) End() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if len(enc.open) == 0 {
+		return errors.New("msgpack: End called with no open array or map")
+	}
+	c := &enc.open[len(enc.open)-1]
+	if c.remaining > 0 {
+		return errEndTooEarly
+	}
+	if c.remaining == UnknownLength {
+		count := c.written
+		if c.ismap {
+			if count%2 != 0 {
+				return errors.New("msgpack: End called on a map with a key but no value")
+			}
+			count /= 2
+		}
+		enc.buf = enc.buf[:backpatchHeader(enc.buf, c.start, len(enc.buf), count, c.ismap)]
+	}
+	enc.open = enc.open[:len(enc.open)-1]
+	return enc.written()
+}
+
+// Writes out everything that is ready: all of it, unless an array or map of
+// unknown length is still open, in which case everything before it. Call
+// this once done encoding.
+func (enc *Encoder) Flush() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	ready := len(enc.buf)
+	for _, c := range enc.open {
+		if c.remaining == UnknownLength {
+			ready = c.start
+			break
+		}
+	}
+	if ready == 0 {
+		return nil
+	}
+	if _, err := enc.w.Write(enc.buf[:ready]); err != nil {
+		enc.err = err
+		return err
+	}
+	enc.buf = enc.buf[:copy(enc.buf, enc.buf[ready:])]
+	for i := range enc.open {
+		enc.open[i].start -= ready
+	}
+	return nil
+}
+
+// Checks there is room for another value in the innermost open container
+func (enc *Encoder) element() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if len(enc.open) > 0 && enc.open[len(enc.open)-1].remaining == 0 {
+		return errors.New("msgpack: more elements written than the array or map was begun with")
+	}
+	return nil
+}
+
+// Counts a finished value against the innermost open container
+func (enc *Encoder) written() error {
+	if len(enc.open) > 0 {
+		c := &enc.open[len(enc.open)-1]
+		if c.remaining > 0 {
+			c.remaining--
+		} else {
+			c.written++
+		}
+	}
+	return enc.finished()
+}
+
+// Writes out what is ready once there is enough of it
+func (enc *Encoder) finished() error {
+	if len(enc.buf) < DEFAULT_ENCODER_BUFFER {
+		return nil
+	}
+	return enc.Flush()
+}
+
+// Makes room for @n more bytes in the buffer
+func (enc *Encoder) grow(n int) {
+	if cap(enc.buf)-len(enc.buf) < n {
+		grown := make([]byte, len(enc.buf), 2*cap(enc.buf)+n)
+		copy(grown, enc.buf)
+		enc.buf = grown
+	}
+}
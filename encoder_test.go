@@ -0,0 +1,137 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncoderKnownLengths(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.BeginMap(2)
+	enc.Encode("a")
+	enc.BeginArray(3)
+	enc.Encode(1)
+	enc.Encode("two")
+	enc.Encode(nil)
+	enc.End()
+	enc.Encode("b")
+	enc.Encode(true)
+	if err := enc.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	// {"a": [1, "two", nil], "b": true}
+	expected := []byte{0x82, 0xa1, 0x61, 0x93, 0x01, 0xa3, 0x74, 0x77, 0x6f, 0xc0, 0xa1, 0x62, 0xc3}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Encoded % x, want % x", buf.Bytes(), expected)
+	}
+}
+
+func TestEncoderUnknownLengths(t *testing.T) {
+	for _, n := range []int{0, 3, 15, 16, 70000} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.BeginArray(UnknownLength)
+		want := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			enc.BeginMap(UnknownLength)
+			enc.Encode("i")
+			enc.Encode(int64(i % 100))
+			enc.End()
+			want[i] = map[string]interface{}{"i": int64(i % 100)}
+		}
+		enc.End()
+		if err := enc.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		data := buf.Bytes()
+		h, err := ReadHeader(data, 0)
+		if err != nil {
+			t.Fatalf("ReadHeader failed: %v", err)
+		}
+		if h.Length != n || h.Size != encodeArrayHeader(make([]byte, maxContainerHeader), 0, n) {
+			t.Errorf("header for %d elements is %v", n, h)
+		}
+		consumed, got := Decode(&data, 0)
+		if consumed != len(data) {
+			t.Errorf("Decode consumed %d of %d bytes", consumed, len(data))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d elements decoded wrong", n)
+		}
+	}
+}
+
+func TestEncoderStreams(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.BeginArray(1000)
+	for i := 0; i < 999; i++ {
+		enc.Encode(strings.Repeat("x", 20))
+	}
+	if buf.Len() == 0 {
+		t.Errorf("nothing written before the array was finished")
+	}
+	enc.Encode("last")
+	enc.End()
+	enc.Flush()
+	data := buf.Bytes()
+	if err := Validate(data); err != nil {
+		t.Errorf("Encoded array doesn't validate: %v", err)
+	}
+}
+
+func TestEncoderErrors(t *testing.T) {
+	enc := NewEncoder(&bytes.Buffer{})
+	if enc.End() == nil {
+		t.Errorf("End with nothing open succeeded")
+	}
+	enc.BeginArray(1)
+	if enc.End() != errEndTooEarly {
+		t.Errorf("End on a short array succeeded")
+	}
+	enc.Encode(1)
+	if enc.Encode(2) == nil {
+		t.Errorf("Encode past the end of an array succeeded")
+	}
+	enc.End()
+
+	enc.BeginMap(UnknownLength)
+	enc.Encode("key")
+	if enc.End() == nil {
+		t.Errorf("End on a map with a missing value succeeded")
+	}
+}
+
+func TestEncoderInvalidUTF8(t *testing.T) {
+	value := map[string]interface{}{"a": []interface{}{"ok", "b\xff"}}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(value); err != nil {
+		t.Errorf("AllowInvalidUTF8 refused a string: %v", err)
+	}
+
+	enc = NewEncoder(&buf)
+	enc.InvalidUTF8 = RejectInvalidUTF8
+	if err := enc.Encode(value); err != ErrInvalidUTF8 {
+		t.Errorf("RejectInvalidUTF8 returned %v for a bad value", err)
+	}
+	if err := enc.Encode(map[string]interface{}{"\xff": 1}); err != ErrInvalidUTF8 {
+		t.Errorf("RejectInvalidUTF8 returned %v for a bad key", err)
+	}
+
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	enc.InvalidUTF8 = InvalidUTF8AsBin
+	enc.Encode([]interface{}{"ok", "b\xff"})
+	enc.Flush()
+	expected := []byte{0x92, 0xa2, 0x6f, 0x6b, 0xc4, 0x2, 0x62, 0xff}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("InvalidUTF8AsBin encoded % x, want % x", buf.Bytes(), expected)
+	}
+}
@@ -0,0 +1,253 @@
+package msgpack
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A struct field as Marshal and Unmarshal see it. Fields of structs tagged
+// `msgpack:",inline"` appear among the fields of the struct holding them,
+// with an index that goes through it
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	// the name encoded as a str, ready to copy out as a map key
+	key []byte
+	// how the field's type encodes itself
+	self selfEncoding
+	// tagged `msgpack:",remain"` with type map[string]Raw
+	remain bool
+}
+
+type fieldList []structField
+
+// Everything Marshal and Unmarshal need to know about a struct type, worked
+// out once per type
+type structPlan struct {
+	fields  fieldList
+	asArray bool
+	// field indexes by exact name
+	byname map[string]int
+	// some fields may be left out of a map: omitempty ones, and ones
+	// inlined through a pointer
+	optional bool
+	// the bytes all the keys take up
+	keysize int
+	// where the map[string]Raw that collects unknown keys is, if the
+	// struct has one
+	remain []int
+}
+
+// reflect.Type -> *structPlan
+var structPlans sync.Map
+
+// Returns the plan for the struct type @t, making it on first use
+func planFor(t reflect.Type) *structPlan {
+	if plan, found := structPlans.Load(t); found {
+		return plan.(*structPlan)
+	}
+	plan := &structPlan{asArray: structAsArray(t)}
+	for _, field := range structFields(t) {
+		if !field.remain {
+			plan.fields = append(plan.fields, field)
+		} else if plan.remain == nil {
+			plan.remain = field.index
+		}
+	}
+	plan.byname = make(map[string]int, len(plan.fields))
+	for i := range plan.fields {
+		field := &plan.fields[i]
+		field.key = make([]byte, 5+len(field.name))
+		field.key = field.key[:encodeString(field.key, 0, field.name)]
+		plan.keysize += len(field.key)
+		plan.byname[field.name] = i
+		field.self = selfEncodingFor(fieldType(t, field.index))
+		if field.omitEmpty || throughPointer(t, field.index) {
+			plan.optional = true
+		}
+	}
+	// another goroutine may have got there first; either plan will do
+	actual, _ := structPlans.LoadOrStore(t, plan)
+	return actual.(*structPlan)
+}
+
+// Returns the type of the field at @index in @t
+func fieldType(t reflect.Type, index []int) reflect.Type {
+	for _, x := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		t = t.Field(x).Type
+	}
+	return t
+}
+
+// Returns true if getting to the field at @index in @t goes through a
+// pointer
+func throughPointer(t reflect.Type, index []int) bool {
+	for _, x := range index[:len(index)-1] {
+		t = t.Field(x).Type
+		if t.Kind() == reflect.Ptr {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if @tag lists @option after the name
+func hasTagOption(tag string, option string) bool {
+	options := strings.Split(tag, ",")
+	for _, o := range options[1:] {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the struct type @t asks to be encoded as an array with a
+// field like
+//
+//	_ struct{} `msgpack:",asArray"`
+func structAsArray(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if (f.Name == "_" || f.Name == "_msgpack") && hasTagOption(f.Tag.Get("msgpack"), "asArray") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the struct fields msgpack knows about, in order. A field inlined
+// from an embedded struct is dropped if the outer struct already has a
+// field by that name, just as Go hides promoted fields
+func structFields(t reflect.Type) fieldList {
+	fields := appendFields(nil, t, nil, map[reflect.Type]bool{})
+	byname := make(map[string]int, len(fields))
+	kept := fields[:0]
+	for _, field := range fields {
+		if i, found := byname[field.name]; found {
+			if len(field.index) < len(kept[i].index) {
+				kept[i] = field
+			}
+			continue
+		}
+		byname[field.name] = len(kept)
+		kept = append(kept, field)
+	}
+	return kept
+}
+
+// Adds the fields of @t to @fields, inlining where tagged. @index leads to
+// @t from the outermost struct, and @seen stops inlining a type in itself
+func appendFields(fields fieldList, t reflect.Type, index []int, seen map[reflect.Type]bool) fieldList {
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("msgpack")
+		name := tag
+		if comma := strings.Index(name, ","); comma >= 0 {
+			name = name[:comma]
+		}
+		if name == "-" {
+			continue
+		}
+		fieldindex := append(append([]int{}, index...), i)
+
+		if hasTagOption(tag, "inline") {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			// an unexported embedded struct's fields are still reachable,
+			// unless it has to be allocated through a pointer
+			reachable := f.PkgPath == "" || (f.Anonymous && f.Type.Kind() == reflect.Struct)
+			if ft.Kind() == reflect.Struct && reachable && !seen[ft] {
+				fields = appendFields(fields, ft, fieldindex, seen)
+				continue
+			}
+		}
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{
+			name:      name,
+			index:     fieldindex,
+			omitEmpty: hasTagOption(tag, "omitempty"),
+			remain:    f.Type == remainType && hasTagOption(tag, "remain"),
+		})
+	}
+	return fields
+}
+
+// Returns the index of the field whose name is @name, or failing that the
+// first one whose name matches it ignoring case. -1 if there is none
+func (plan *structPlan) lookup(name []byte) int {
+	if i, found := plan.byname[string(name)]; found {
+		return i
+	}
+	for i := range plan.fields {
+		if strings.EqualFold(plan.fields[i].name, string(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the field of the struct @v at @index, and false if it is
+// inside an inlined struct pointer that is nil
+func fieldToEncode(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// Returns the field of the struct @v at @index, allocating any nil inlined
+// struct pointers on the way
+func fieldToDecode(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf((*isZeroer)(nil)).Elem()
+
+// Returns true if the field value @v should be left out with omitempty: it
+// is the zero value for its type, or has an IsZero method that says so
+func isEmptyValue(v reflect.Value) bool {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return true
+	}
+	if v.Type().Implements(isZeroerType) {
+		return v.Interface().(isZeroer).IsZero()
+	}
+	if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(isZeroerType) {
+		return v.Addr().Interface().(isZeroer).IsZero()
+	}
+	return v.IsZero()
+}
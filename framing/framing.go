@@ -0,0 +1,202 @@
+// Package framing reads and writes streams of msgpack messages, such as
+// over a TCP connection, by putting a length in front of each one.
+//
+// A frame is a length prefix, the msgpack-encoded message, and, if
+// checksums are turned on, a CRC32 (IEEE) of the message as a 4 byte
+// big-endian trailer. The length is of the message alone and is written
+// either as a uvarint (as in encoding/binary) or as a 4 byte big-endian
+// integer. Both ends of a stream must agree on the prefix and on whether
+// there are checksums.
+package framing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/gtfierro/msgpack"
+)
+
+// How the length of each frame is written
+type Prefix int
+
+const (
+	// a uvarint, 1 to 5 bytes for the frame sizes allowed here
+	Varint Prefix = iota
+	// a 4 byte big-endian integer
+	Fixed32
+)
+
+const DEFAULT_MAX_FRAME_SIZE = 16 * 1024 * 1024
+
+var (
+	ErrFrameTooLarge = errors.New("framing: frame is larger than the maximum frame size")
+	ErrChecksum      = errors.New("framing: frame checksum does not match")
+	ErrTrailingBytes = errors.New("framing: frame holds more than one msgpack object")
+)
+
+// A Writer writes framed messages to an underlying io.Writer. Each frame is
+// written with a single call to Write. A Writer is not safe for concurrent
+// use.
+type Writer struct {
+	Prefix Prefix
+	// messages longer than this are refused with ErrFrameTooLarge
+	MaxFrameSize int
+	// add a CRC32 trailer to every frame
+	Checksum bool
+
+	w   io.Writer
+	buf []byte
+}
+
+// Returns a Writer using varint prefixes, DEFAULT_MAX_FRAME_SIZE and no
+// checksums
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		Prefix:       Varint,
+		MaxFrameSize: DEFAULT_MAX_FRAME_SIZE,
+		w:            w,
+	}
+}
+
+// Encodes @v with msgpack and writes it as one frame
+func (w *Writer) WriteMessage(v interface{}) error {
+	message, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.WriteFrame(message)
+}
+
+// Writes @message, which must already be msgpack-encoded, as one frame
+func (w *Writer) WriteFrame(message []byte) error {
+	// leave room in front for the longest prefix, then move the prefix up
+	// against the message once its length is known
+	w.buf = append(append(w.buf[:0], 0, 0, 0, 0, 0), message...)
+	return w.writeFrame(w.buf, 5)
+}
+
+// Fills in the prefix and trailer of the message in @buf, which starts at
+// @start with the prefix to go in the bytes before it, and writes it out
+func (w *Writer) writeFrame(buf []byte, start int) error {
+	length := len(buf) - start
+	if length > w.MaxFrameSize || uint64(length) > math.MaxUint32 {
+		return ErrFrameTooLarge
+	}
+	var prefix [binary.MaxVarintLen32]byte
+	var n int
+	switch w.Prefix {
+	case Fixed32:
+		binary.BigEndian.PutUint32(prefix[:], uint32(length))
+		n = 4
+	default:
+		n = binary.PutUvarint(prefix[:], uint64(length))
+	}
+	if w.Checksum {
+		var trailer [4]byte
+		binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(buf[start:]))
+		buf = append(buf, trailer[:]...)
+		w.buf = buf
+	}
+	copy(buf[start-n:], prefix[:n])
+	_, err := w.w.Write(buf[start-n:])
+	return err
+}
+
+// A Reader reads framed messages from an underlying io.Reader. It buffers
+// its input, so it may read past the end of the last frame. A Reader is
+// not safe for concurrent use.
+type Reader struct {
+	Prefix Prefix
+	// frames longer than this are refused with ErrFrameTooLarge, without
+	// reading them
+	MaxFrameSize int
+	// expect a CRC32 trailer on every frame
+	Checksum bool
+	// if set, ReadMessage decodes with this instead of msgpack.Decode
+	Decoder *msgpack.Decoder
+
+	r   *bufio.Reader
+	buf []byte
+}
+
+// Returns a Reader expecting varint prefixes, DEFAULT_MAX_FRAME_SIZE and no
+// checksums
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		Prefix:       Varint,
+		MaxFrameSize: DEFAULT_MAX_FRAME_SIZE,
+		r:            bufio.NewReader(r),
+	}
+}
+
+// Reads the next frame and returns the msgpack message inside it. The
+// returned slice is only valid until the next call to ReadFrame or
+// ReadMessage. Returns io.EOF if the stream ends cleanly between frames and
+// io.ErrUnexpectedEOF if it ends partway through one.
+func (r *Reader) ReadFrame() ([]byte, error) {
+	var length uint64
+	switch r.Prefix {
+	case Fixed32:
+		var prefix [4]byte
+		if _, err := io.ReadFull(r.r, prefix[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint32(prefix[:]))
+	default:
+		var err error
+		if length, err = binary.ReadUvarint(r.r); err != nil {
+			return nil, err
+		}
+	}
+	if length > uint64(r.MaxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	size := int(length)
+	if r.Checksum {
+		size += 4
+	}
+	if cap(r.buf) < size {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	message := r.buf[:length]
+	if r.Checksum && crc32.ChecksumIEEE(message) != binary.BigEndian.Uint32(r.buf[length:]) {
+		return nil, ErrChecksum
+	}
+	return message, nil
+}
+
+// used when a Reader has no Decoder, shared by every Reader as
+// msgpack.Decoder allows
+var defaultDecoder = &msgpack.Decoder{}
+
+// Reads the next frame and decodes the msgpack message inside it. The
+// message must be exactly one well-formed msgpack object.
+func (r *Reader) ReadMessage() (interface{}, error) {
+	message, err := r.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	dec := r.Decoder
+	if dec == nil {
+		dec = defaultDecoder
+	}
+	end, value, err := dec.TryDecode(&message, 0)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(message) {
+		return nil, ErrTrailingBytes
+	}
+	return value, nil
+}
@@ -0,0 +1,142 @@
+package framing
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/gtfierro/msgpack"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, prefix := range []Prefix{Varint, Fixed32} {
+		for _, checksum := range []bool{false, true} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.Prefix = prefix
+			w.Checksum = checksum
+			r := NewReader(&buf)
+			r.Prefix = prefix
+			r.Checksum = checksum
+
+			messages := []interface{}{
+				map[string]interface{}{"a": int64(1), "b": "two"},
+				"abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz",
+				[]interface{}{true, nil, 2.5},
+			}
+			for _, m := range messages {
+				if err := w.WriteMessage(m); err != nil {
+					t.Fatalf("WriteMessage failed: %v", err)
+				}
+			}
+			if err := w.WriteFrame([]byte{0x05}); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+
+			v, err := r.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage failed: %v", err)
+			}
+			if m := v.(map[string]interface{}); m["a"].(int64) != 1 || m["b"].(string) != "two" {
+				t.Errorf("ReadMessage should be %v but was %v", messages[0], v)
+			}
+			if v, err = r.ReadMessage(); err != nil || v.(string) != messages[1].(string) {
+				t.Errorf("ReadMessage should be %v but was %v (%v)", messages[1], v, err)
+			}
+			if v, err = r.ReadMessage(); err != nil || len(v.([]interface{})) != 3 {
+				t.Errorf("ReadMessage should be %v but was %v (%v)", messages[2], v, err)
+			}
+			frame, err := r.ReadFrame()
+			if err != nil || !bytes.Equal(frame, []byte{0x05}) {
+				t.Errorf("ReadFrame should be 05 but was %x (%v)", frame, err)
+			}
+			if _, err = r.ReadFrame(); err != io.EOF {
+				t.Errorf("ReadFrame should return io.EOF at the end but returned %v", err)
+			}
+		}
+	}
+}
+
+func TestFixed32Layout(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Prefix = Fixed32
+	w.WriteMessage(true)
+	if !bytes.Equal(buf.Bytes(), []byte{0x0, 0x0, 0x0, 0x1, 0xc3}) {
+		t.Errorf("Frame should be 00000001c3 but was %x", buf.Bytes())
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.MaxFrameSize = 4
+	if err := w.WriteMessage("too long"); err != ErrFrameTooLarge {
+		t.Errorf("WriteMessage should fail with ErrFrameTooLarge but returned %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Nothing should be written for a frame that is too large")
+	}
+
+	w.MaxFrameSize = DEFAULT_MAX_FRAME_SIZE
+	w.WriteMessage("too long")
+	r := NewReader(&buf)
+	r.MaxFrameSize = 4
+	if _, err := r.ReadFrame(); err != ErrFrameTooLarge {
+		t.Errorf("ReadFrame should fail with ErrFrameTooLarge but returned %v", err)
+	}
+}
+
+func TestWriteMessageUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteMessage(make(chan int)); err == nil {
+		t.Errorf("WriteMessage of a chan should fail")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Nothing should be written for a message that can't be encoded")
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Checksum = true
+	w.WriteMessage("hello")
+	frame := buf.Bytes()
+	frame[3] ^= 0xff
+	r := NewReader(&buf)
+	r.Checksum = true
+	if _, err := r.ReadFrame(); err != ErrChecksum {
+		t.Errorf("ReadFrame should fail with ErrChecksum but returned %v", err)
+	}
+}
+
+func TestBadFrames(t *testing.T) {
+	// stream ends partway through a frame
+	r := NewReader(bytes.NewReader([]byte{0x3, 0x92, 0x1}))
+	if _, err := r.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadFrame should fail with io.ErrUnexpectedEOF but returned %v", err)
+	}
+	// frame holds a truncated object
+	r = NewReader(bytes.NewReader([]byte{0x2, 0x92, 0x1}))
+	if _, err := r.ReadMessage(); err == nil {
+		t.Errorf("ReadMessage should fail on a truncated object")
+	}
+	// frame holds two objects
+	r = NewReader(bytes.NewReader([]byte{0x2, 0xc3, 0xc3}))
+	if _, err := r.ReadMessage(); err != ErrTrailingBytes {
+		t.Errorf("ReadMessage should fail with ErrTrailingBytes but returned %v", err)
+	}
+}
+
+func TestReaderDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteMessage(map[string]interface{}{"key": int64(1)})
+	r := NewReader(&buf)
+	r.Decoder = msgpack.NewDecoder()
+	v, err := r.ReadMessage()
+	if err != nil || v.(map[string]interface{})["key"].(int64) != 1 {
+		t.Errorf("ReadMessage should be map[key:1] but was %v (%v)", v, err)
+	}
+}
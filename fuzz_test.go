@@ -0,0 +1,136 @@
+package msgpack
+
+import (
+	"bytes"
+	"testing"
+)
+
+// One object of every format Decode handles, plus the ones it doesn't
+var fuzzSeeds = [][]byte{
+	{0x05},                   // positive fixint
+	{0xe5},                   // negative fixint
+	{0x81, 0xa1, 0x61, 0x01}, // fixmap
+	{0x92, 0x01, 0xc0},       // fixarray
+	{0xa3, 0x61, 0x62, 0x63}, // fixstr
+	{0xc0},                   // nil
+	{0xc1},                   // never used
+	{0xc2},                   // false
+	{0xc3},                   // true
+	{0xc4, 0x02, 0x01, 0x02}, // bin8
+	{0xc5, 0x00, 0x01, 0xff},
+	{0xc6, 0x00, 0x00, 0x00, 0x01, 0xff},
+	{0xc7, 0x01, 0x05, 0xff}, // ext8
+	{0xc8, 0x00, 0x01, 0x05, 0xff},
+	{0xc9, 0x00, 0x00, 0x00, 0x01, 0x05, 0xff},
+	{0xca, 0x3f, 0x80, 0x00, 0x00}, // float32
+	{0xcb, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	{0xcc, 0xff}, // uint8
+	{0xcd, 0x01, 0x00},
+	{0xce, 0x00, 0x01, 0x00, 0x00},
+	{0xcf, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00},
+	{0xd0, 0x80}, // int8
+	{0xd1, 0x80, 0x00},
+	{0xd2, 0x80, 0x00, 0x00, 0x00},
+	{0xd3, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	{0xd4, 0x05, 0x01}, // fixext1
+	{0xd5, 0x05, 0x01, 0x02},
+	{0xd6, 0x05, 0x01, 0x02, 0x03, 0x04},
+	{0xd7, 0x05, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+	{0xd8, 0x05, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+	{0xd9, 0x01, 0x61}, // str8
+	{0xda, 0x00, 0x01, 0x61},
+	{0xdb, 0x00, 0x00, 0x00, 0x01, 0x61},
+	{0xdc, 0x00, 0x01, 0xc3}, // array16
+	{0xdd, 0x00, 0x00, 0x00, 0x01, 0xc3},
+	{0xde, 0x00, 0x01, 0xa1, 0x61, 0xc2}, // map16
+	{0xdf, 0x00, 0x00, 0x00, 0x01, 0xa1, 0x61, 0xc2},
+	// things that used to crash
+	{0xcd, 0x01},                   // truncated uint16
+	{0xcb, 0x3f, 0xf0},             // truncated float64
+	{0xdb, 0xff, 0xff, 0xff, 0xff}, // str32 far longer than the input
+	{0xdd, 0xff, 0xff, 0xff, 0xff}, // array32 far longer than the input
+	{0x81, 0x01, 0x02},             // int map key
+	{0x81, 0xa5, 0x61},             // truncated map key
+	{0x91, 0x91, 0x91, 0x91, 0x90}, // nested arrays
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		end, value := Decode(&data, 0)
+		if end == 0 {
+			if value != nil {
+				t.Fatalf("Decode failed but returned %v", value)
+			}
+			return
+		}
+		// anything Decode takes must be well-formed
+		skipped, err := Skip(data, 0)
+		if err != nil || skipped != end {
+			t.Fatalf("Decode ended at %d but Skip at %d (%v)", end, skipped, err)
+		}
+		(&Decoder{OrderedMaps: true, DuplicateKeys: ErrorOnDuplicate, InvalidUTF8: RejectInvalidUTF8}).Decode(&data, 0)
+		var any interface{}
+		if err = Unmarshal(data[:end], &any); err != nil {
+			t.Fatalf("Decode succeeded but Unmarshal failed: %v", err)
+		}
+	})
+}
+
+// Whatever Decode returns encodes to something that decodes to the same
+// thing. The maps are ordered so that the encodings can be compared
+func FuzzRoundTrip(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := &Decoder{OrderedMaps: true}
+		end, value := dec.Decode(&data, 0)
+		if end == 0 {
+			return
+		}
+		first, err := Marshal(value)
+		if err != nil {
+			t.Fatalf("Marshal(%#v) failed: %v", value, err)
+		}
+		end, value = dec.Decode(&first, 0)
+		if end != len(first) {
+			t.Fatalf("Decode of %x ended at %d", first, end)
+		}
+		second, err := Marshal(value)
+		if err != nil {
+			t.Fatalf("Marshal(%#v) failed: %v", value, err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Fatalf("%x decoded and encoded again as %x", first, second)
+		}
+	})
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, input := range fuzzSeeds {
+		if _, err := Skip(input, 0); err == nil {
+			continue
+		}
+		if end, value := Decode(&input, 0); end != 0 || value != nil {
+			t.Errorf("Decode(%x) should fail, not return %v ending at %d", input, value, end)
+		}
+	}
+}
+
+func TestDecodeMaxDepth(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x91}, 20)
+	deep = append(deep, 0xc0)
+	if end, _ := (&Decoder{MaxDepth: 20}).Decode(&deep, 0); end != len(deep) {
+		t.Errorf("20 nested arrays should be allowed with MaxDepth 20")
+	}
+	if end, _ := (&Decoder{MaxDepth: 19}).Decode(&deep, 0); end != 0 {
+		t.Errorf("20 nested arrays should be refused with MaxDepth 19")
+	}
+	var any interface{}
+	if err := (&Decoder{MaxDepth: 19}).Unmarshal(deep, &any); err == nil {
+		t.Errorf("Unmarshal should refuse 20 nested arrays with MaxDepth 19")
+	}
+}
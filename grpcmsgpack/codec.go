@@ -0,0 +1,42 @@
+// Package grpcmsgpack lets gRPC carry messages encoded with msgpack, so
+// services can exchange plain Go structs without protobuf.
+//
+// Importing the package registers a codec named "msgpack" with
+// google.golang.org/grpc/encoding. Servers pick it automatically for
+// requests with the content subtype "msgpack"; clients ask for it with the
+// grpc.CallContentSubtype(grpcmsgpack.Name) call option:
+//
+//	conn, err := grpc.NewClient(target,
+//		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(grpcmsgpack.Name)), ...)
+//
+// Messages are encoded with msgpack.Marshal and decoded with
+// msgpack.Unmarshal.
+package grpcmsgpack
+
+import (
+	"github.com/gtfierro/msgpack"
+	"google.golang.org/grpc/encoding"
+)
+
+// The name the codec is registered under, and the content subtype that
+// selects it
+const Name = "msgpack"
+
+// Codec implements encoding.Codec using this repository's msgpack package
+type Codec struct{}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (Codec) Name() string {
+	return Name
+}
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
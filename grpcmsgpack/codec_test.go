@@ -0,0 +1,113 @@
+package grpcmsgpack
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type GreetRequest struct {
+	Name  string
+	Times int
+}
+
+type GreetReply struct {
+	Greetings []string `msgpack:"greetings"`
+}
+
+type greeter interface {
+	Greet(context.Context, *GreetRequest) (*GreetReply, error)
+}
+
+type greeterServer struct{}
+
+func (greeterServer) Greet(ctx context.Context, req *GreetRequest) (*GreetReply, error) {
+	if req.Times < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative times")
+	}
+	reply := &GreetReply{}
+	for i := 0; i < req.Times; i++ {
+		reply.Greetings = append(reply.Greetings, "hello "+req.Name)
+	}
+	return reply, nil
+}
+
+// what protoc would generate for a Greeter service
+var greeterDesc = grpc.ServiceDesc{
+	ServiceName: "test.Greeter",
+	HandlerType: (*greeter)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Greet",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(GreetRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(greeter).Greet(ctx, req)
+		},
+	}},
+}
+
+func dial(t *testing.T) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	server.RegisterService(&greeterDesc, greeterServer{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(Name)),
+	)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGreet(t *testing.T) {
+	conn := dial(t)
+	var reply GreetReply
+	err := conn.Invoke(context.Background(), "/test.Greeter/Greet", &GreetRequest{Name: "msgpack", Times: 2}, &reply)
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if len(reply.Greetings) != 2 || reply.Greetings[0] != "hello msgpack" {
+		t.Errorf("Reply should be two greetings but was %+v", reply)
+	}
+}
+
+func TestGreetError(t *testing.T) {
+	conn := dial(t)
+	var reply GreetReply
+	err := conn.Invoke(context.Background(), "/test.Greeter/Greet", &GreetRequest{Name: "msgpack", Times: -1}, &reply)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Invoke should fail with InvalidArgument but returned %v", err)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	codec := encoding.GetCodec(Name)
+	if codec == nil {
+		t.Fatalf("No codec is registered as %v", Name)
+	}
+	data, err := codec.Marshal(&GreetRequest{Name: "x", Times: 1})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var req GreetRequest
+	if err = codec.Unmarshal(data, &req); err != nil || req.Name != "x" || req.Times != 1 {
+		t.Errorf("Unmarshal should be {x 1} but was %+v (%v)", req, err)
+	}
+}
@@ -0,0 +1,198 @@
+package msgpack
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The kind of value a msgpack object holds
+type Type int
+
+const (
+	InvalidType Type = iota
+	NilType
+	BoolType
+	IntType
+	UintType
+	FloatType
+	StrType
+	BinType
+	ArrayType
+	MapType
+	ExtType
+)
+
+var typeNames = []string{"invalid", "nil", "bool", "int", "uint", "float", "str", "bin", "array", "map", "ext"}
+
+func (t Type) String() string {
+	if t < 0 || int(t) >= len(typeNames) {
+		return "Type(" + strconv.Itoa(int(t)) + ")"
+	}
+	return typeNames[t]
+}
+
+// A DecodeError reports malformed msgpack input and where it was found
+type DecodeError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("msgpack: %s at offset %d", e.Msg, e.Offset)
+}
+
+func truncatedError(offset int) error {
+	return &DecodeError{Offset: offset, Msg: "unexpected end of input"}
+}
+
+// Header describes the leading bytes of one msgpack object: the format byte
+// plus whatever length and extension type fields follow it.
+type Header struct {
+	Type Type
+	// the name of the format as in the msgpack spec, e.g. "fixmap" or "str8"
+	Format string
+	// how many bytes the header itself takes up
+	Size int
+	// for arrays and maps, the number of elements (maps count key/value
+	// pairs). For everything else, the number of bytes that follow the
+	// header: the string or binary data, ext payload, or the big-endian
+	// number. Fixints, nil and booleans have a Length of 0
+	Length int
+	// the extension type of ext and fixext objects
+	Ext int8
+}
+
+// Returns e.g. "fixmap(3)" or "str8(40)" for things with a length, and
+// just the format name for numbers, nil and booleans
+func (h Header) String() string {
+	switch h.Type {
+	case StrType, BinType, ArrayType, MapType:
+		return h.Format + "(" + strconv.Itoa(h.Length) + ")"
+	case ExtType:
+		return h.Format + "(" + strconv.Itoa(int(h.Ext)) + ", " + strconv.Itoa(h.Length) + ")"
+	}
+	return h.Format
+}
+
+// describes the formats with type bytes 0xc0 through 0xdf
+type formatInfo struct {
+	typ    Type
+	name   string
+	fixed  int // length of a fixed-size payload
+	lenlen int // bytes in the length field of a variable-size payload
+}
+
+var formats = [32]formatInfo{
+	{NilType, "nil", 0, 0},
+	{InvalidType, "never used", 0, 0},
+	{BoolType, "false", 0, 0},
+	{BoolType, "true", 0, 0},
+	{BinType, "bin8", 0, 1},
+	{BinType, "bin16", 0, 2},
+	{BinType, "bin32", 0, 4},
+	{ExtType, "ext8", 0, 1},
+	{ExtType, "ext16", 0, 2},
+	{ExtType, "ext32", 0, 4},
+	{FloatType, "float32", 4, 0},
+	{FloatType, "float64", 8, 0},
+	{UintType, "uint8", 1, 0},
+	{UintType, "uint16", 2, 0},
+	{UintType, "uint32", 4, 0},
+	{UintType, "uint64", 8, 0},
+	{IntType, "int8", 1, 0},
+	{IntType, "int16", 2, 0},
+	{IntType, "int32", 4, 0},
+	{IntType, "int64", 8, 0},
+	{ExtType, "fixext1", 1, 0},
+	{ExtType, "fixext2", 2, 0},
+	{ExtType, "fixext4", 4, 0},
+	{ExtType, "fixext8", 8, 0},
+	{ExtType, "fixext16", 16, 0},
+	{StrType, "str8", 0, 1},
+	{StrType, "str16", 0, 2},
+	{StrType, "str32", 0, 4},
+	{ArrayType, "array16", 0, 2},
+	{ArrayType, "array32", 0, 4},
+	{MapType, "map16", 0, 2},
+	{MapType, "map32", 0, 4},
+}
+
+// Reads the header of the msgpack object starting at @offset. Unlike
+// Decode, ReadHeader never panics: it returns a *DecodeError if the format
+// byte is invalid or if the input is too short to hold the header and the
+// bytes it says follow it. For arrays and maps it checks there are at least
+// enough bytes left for the elements to be one byte each.
+func ReadHeader(input []byte, offset int) (Header, error) {
+	h, err := readHeader(input, offset)
+	if err != nil {
+		return h, err
+	}
+	remaining := len(input) - offset - h.Size
+	switch h.Type {
+	case ArrayType:
+		if h.Length > remaining {
+			return h, truncatedError(offset)
+		}
+	case MapType:
+		if h.Length > remaining/2 {
+			return h, truncatedError(offset)
+		}
+	default:
+		if h.Length > remaining {
+			return h, truncatedError(offset)
+		}
+	}
+	return h, nil
+}
+
+// Returns the size of the header that starts with format byte @c
+func headerSize(c byte) int {
+	if c < 0xc0 || c >= 0xe0 {
+		return 1
+	}
+	f := formats[c-0xc0]
+	if f.typ == ExtType {
+		return 2 + f.lenlen
+	}
+	return 1 + f.lenlen
+}
+
+// Like ReadHeader, but only checks that the header itself is all there
+func readHeader(input []byte, offset int) (Header, error) {
+	var h Header
+	if offset < 0 || offset >= len(input) {
+		return h, truncatedError(offset)
+	}
+	c := input[offset]
+	switch {
+	case c <= 0x7f:
+		h = Header{Type: IntType, Format: "fixint", Size: 1}
+	case c <= 0x8f:
+		h = Header{Type: MapType, Format: "fixmap", Size: 1, Length: int(c & 0x0f)}
+	case c <= 0x9f:
+		h = Header{Type: ArrayType, Format: "fixarray", Size: 1, Length: int(c & 0x0f)}
+	case c <= 0xbf:
+		h = Header{Type: StrType, Format: "fixstr", Size: 1, Length: int(c & 0x1f)}
+	case c >= 0xe0:
+		h = Header{Type: IntType, Format: "negfixint", Size: 1}
+	default:
+		f := formats[c-0xc0]
+		if f.typ == InvalidType {
+			return h, &DecodeError{Offset: offset, Msg: fmt.Sprintf("invalid format byte 0x%x", c)}
+		}
+		h = Header{Type: f.typ, Format: f.name, Size: 1 + f.lenlen, Length: f.fixed}
+		if f.typ == ExtType {
+			h.Size += 1
+		}
+		if offset+h.Size > len(input) {
+			return h, truncatedError(offset)
+		}
+		if f.lenlen > 0 {
+			h.Length = int(getUint(&input, offset+1, f.lenlen))
+		}
+		if f.typ == ExtType {
+			h.Ext = int8(input[offset+h.Size-1])
+		}
+	}
+	return h, nil
+}
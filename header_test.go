@@ -0,0 +1,49 @@
+package msgpack
+
+import (
+	"testing"
+)
+
+func TestReadHeader(t *testing.T) {
+	for _, test := range []struct {
+		input  []byte
+		header string
+		size   int
+		length int
+	}{
+		{[]byte{0x05}, "fixint", 1, 0},
+		{[]byte{0xf0}, "negfixint", 1, 0},
+		{[]byte{0x83, 0x1, 0x1, 0x1, 0x1, 0x1, 0x1}, "fixmap(3)", 1, 3},
+		{[]byte{0xd9, 0x2, 0x61, 0x62}, "str8(2)", 2, 2},
+		{[]byte{0xcd, 0x1, 0x0}, "uint16", 1, 2},
+		{[]byte{0xc5, 0x0, 0x1, 0xff}, "bin16(1)", 3, 1},
+		{[]byte{0xd6, 0xff, 0x0, 0x0, 0x0, 0x0}, "fixext4(-1, 4)", 2, 4},
+		{[]byte{0xc7, 0x1, 0x5, 0x0}, "ext8(5, 1)", 3, 1},
+		{[]byte{0xdc, 0x0, 0x1, 0xc0}, "array16(1)", 3, 1},
+	} {
+		h, err := ReadHeader(test.input, 0)
+		if err != nil {
+			t.Errorf("ReadHeader(%x) failed: %v", test.input, err)
+			continue
+		}
+		if h.String() != test.header || h.Size != test.size || h.Length != test.length {
+			t.Errorf("ReadHeader(%x) should be %v size %v length %v but was %v size %v length %v", test.input, test.header, test.size, test.length, h, h.Size, h.Length)
+		}
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	for _, input := range [][]byte{
+		{},
+		{0xc1},                               // never used
+		{0xcd, 0x1},                          // short uint16
+		{0xd9, 0x5, 0x61},                    // short str8
+		{0xdd, 0xff, 0xff, 0xff},             // short length
+		{0xdd, 0xff, 0xff, 0xff, 0xff, 0xc0}, // more elements than bytes
+		{0x82, 0xc0, 0xc0, 0xc0},             // map needs two bytes per pair
+	} {
+		if _, err := ReadHeader(input, 0); err == nil {
+			t.Errorf("ReadHeader(%x) should have failed", input)
+		}
+	}
+}
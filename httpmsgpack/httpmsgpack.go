@@ -0,0 +1,193 @@
+// Package httpmsgpack reads and writes HTTP bodies as msgpack or JSON,
+// depending on what the client sent and asked for.
+//
+// Request bodies are decoded according to their Content-Type:
+// application/msgpack and application/x-msgpack are decoded with
+// msgpack.Unmarshal, and application/json (or no Content-Type at all) with
+// encoding/json. Responses are encoded as msgpack if the client's Accept
+// header prefers a msgpack type over JSON, or if it has no Accept header
+// but sent its request as msgpack. Everything else gets JSON.
+package httpmsgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gtfierro/msgpack"
+)
+
+const (
+	ContentType    = "application/msgpack"
+	ContentTypeAlt = "application/x-msgpack"
+	JSONType       = "application/json"
+)
+
+const DEFAULT_MAX_BODY_SIZE = 1024 * 1024
+
+// A Reader decodes request bodies with its own limits. It is safe for
+// concurrent use as long as its fields aren't changed while it is in use
+type Reader struct {
+	// bodies longer than this are refused
+	MaxBodySize int64
+}
+
+// Returns a Reader with DEFAULT_MAX_BODY_SIZE
+func NewReader() *Reader {
+	return &Reader{MaxBodySize: DEFAULT_MAX_BODY_SIZE}
+}
+
+// used by the package-level ReadRequest
+var defaultReader = NewReader()
+
+// An Error is returned by ReadRequest when the request is at fault. Status
+// is the HTTP status to reply with
+type Error struct {
+	Status int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %v", e.Status, http.StatusText(e.Status), e.Err)
+}
+
+func isMsgpack(mediatype string) bool {
+	return mediatype == ContentType || mediatype == ContentTypeAlt
+}
+
+// Decodes the body of @r into @v according to its Content-Type. Returns an
+// *Error with status 415 if the Content-Type isn't msgpack or JSON, 413 if
+// the body is longer than DEFAULT_MAX_BODY_SIZE, and 400 if it can't be
+// decoded into @v. Other errors come from reading the body.
+func ReadRequest(r *http.Request, v interface{}) error {
+	return defaultReader.ReadRequest(r, v)
+}
+
+// Like the package-level ReadRequest, but refusing bodies longer than
+// MaxBodySize
+func (rd *Reader) ReadRequest(r *http.Request, v interface{}) error {
+	mediatype := JSONType
+	if header := r.Header.Get("Content-Type"); header != "" {
+		var err error
+		if mediatype, _, err = mime.ParseMediaType(header); err != nil {
+			return &Error{Status: http.StatusUnsupportedMediaType, Err: err}
+		}
+	}
+	if !isMsgpack(mediatype) && mediatype != JSONType {
+		return &Error{Status: http.StatusUnsupportedMediaType, Err: fmt.Errorf("can't decode %s", mediatype)}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, rd.MaxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > rd.MaxBodySize {
+		return &Error{Status: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("body is longer than %d bytes", rd.MaxBodySize)}
+	}
+
+	if isMsgpack(mediatype) {
+		err = msgpack.Unmarshal(body, v)
+	} else {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		return &Error{Status: http.StatusBadRequest, Err: err}
+	}
+	return nil
+}
+
+// Returns the q value Accept gives @mediatype, going by the most specific
+// range that matches it. 0 if nothing matches
+func quality(accept string, mediatype string) float64 {
+	q, specificity := 0.0, -1
+	slash := strings.Index(mediatype, "/")
+	for _, part := range strings.Split(accept, ",") {
+		rangetype, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		var s int
+		switch {
+		case rangetype == mediatype:
+			s = 2
+		case rangetype == mediatype[:slash+1]+"*":
+			s = 1
+		case rangetype == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity = s
+		q = 1
+		if value, found := params["q"]; found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q
+}
+
+// Returns the media type to answer @r with
+func negotiate(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		if mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); isMsgpack(mediatype) {
+			return mediatype
+		}
+		return JSONType
+	}
+	jsonq := quality(accept, JSONType)
+	best, bestq := JSONType, jsonq
+	for _, mediatype := range []string{ContentType, ContentTypeAlt} {
+		if q := quality(accept, mediatype); q > bestq {
+			best, bestq = mediatype, q
+		}
+	}
+	return best
+}
+
+// Encodes @v as msgpack or JSON, whichever the client that sent @r prefers,
+// and writes it as the response with @status. If @v can't be encoded,
+// nothing is written and the error is returned, so the caller can still
+// send an error response.
+func WriteResponse(w http.ResponseWriter, r *http.Request, status int, v interface{}) error {
+	mediatype := negotiate(r)
+	var body []byte
+	var err error
+	if isMsgpack(mediatype) {
+		body, err = msgpack.Marshal(v)
+	} else {
+		var buf bytes.Buffer
+		err = json.NewEncoder(&buf).Encode(v)
+		body = buf.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", mediatype)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	_, err = w.Write(body)
+	return err
+}
+
+// Replies to @r with the status from @err if it is an *Error, and 500
+// otherwise, with a body of {"error": message}
+func WriteError(w http.ResponseWriter, r *http.Request, err error) error {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*Error); ok {
+		status = e.Status
+	}
+	return WriteResponse(w, r, status, map[string]interface{}{"error": err.Error()})
+}
@@ -0,0 +1,108 @@
+package httpmsgpack
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gtfierro/msgpack"
+)
+
+type point struct {
+	X int `json:"x" msgpack:"x"`
+	Y int `json:"y" msgpack:"y"`
+}
+
+// echoes a point back, doubled
+func handler(w http.ResponseWriter, r *http.Request) {
+	var p point
+	if err := ReadRequest(r, &p); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	WriteResponse(w, r, http.StatusOK, map[string]interface{}{"x": 2 * p.X, "y": 2 * p.Y})
+}
+
+func serve(contenttype, accept string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	if contenttype != "" {
+		r.Header.Set("Content-Type", contenttype)
+	}
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestMsgpackRequest(t *testing.T) {
+	body, _ := msgpack.Marshal(map[string]interface{}{"x": 1, "y": 2})
+	for _, contenttype := range []string{ContentType, ContentTypeAlt} {
+		w := serve(contenttype, "", body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Status should be 200 but was %v: %v", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != contenttype {
+			t.Errorf("Response should be %v but was %v", contenttype, w.Header().Get("Content-Type"))
+		}
+		var p point
+		if err := msgpack.Unmarshal(w.Body.Bytes(), &p); err != nil || p.X != 2 || p.Y != 4 {
+			t.Errorf("Response should be {2 4} but was %+v (%v)", p, err)
+		}
+	}
+}
+
+func TestJSONRequest(t *testing.T) {
+	for _, contenttype := range []string{"", "application/json; charset=utf-8"} {
+		w := serve(contenttype, "", []byte(`{"x": 3, "y": 4}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Status should be 200 but was %v: %v", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != JSONType || strings.TrimSpace(w.Body.String()) != `{"x":6,"y":8}` {
+			t.Errorf("Response should be JSON {\"x\":6,\"y\":8} but was %v %v", w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	body := []byte(`{"x": 1, "y": 1}`)
+	for _, test := range []struct {
+		accept   string
+		expected string
+	}{
+		{"application/msgpack", ContentType},
+		{"application/x-msgpack", ContentTypeAlt},
+		{"application/json", JSONType},
+		{"*/*", JSONType},
+		{"text/html", JSONType},
+		{"application/json;q=0.5, application/msgpack", ContentType},
+		{"application/json, application/msgpack;q=0.9", JSONType},
+		{"application/*;q=0.2, application/msgpack;q=0", JSONType},
+	} {
+		w := serve(JSONType, test.accept, body)
+		if got := w.Header().Get("Content-Type"); got != test.expected {
+			t.Errorf("Accept %v should get %v but got %v", test.accept, test.expected, got)
+		}
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	if w := serve("text/plain", "", []byte("hi")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain should get 415 but got %v", w.Code)
+	}
+	if w := serve(ContentType, "", []byte{0x92, 0x1}); w.Code != http.StatusBadRequest {
+		t.Errorf("Truncated msgpack should get 400 but got %v", w.Code)
+	}
+	if w := serve(JSONType, "", []byte(`{"x": "nope"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("Mistyped JSON should get 400 but got %v", w.Code)
+	}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"x": 1}`))
+	var p point
+	err, ok := (&Reader{MaxBodySize: 4}).ReadRequest(r, &p).(*Error)
+	if !ok || err.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Long body should get 413 but got %v", err)
+	}
+}
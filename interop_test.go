@@ -0,0 +1,200 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	vmihailenco "gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// A random value of a type both this package and vmihailenco can encode:
+// nil, bool, int64, uint64, float32, float64, string, []byte, and
+// []interface{} and map[string]interface{} of those
+type interopValue struct {
+	V interface{}
+}
+
+// lengths where the format of a str, bin, array or map changes
+var interopLengths = []int{0, 1, 15, 16, 31, 32, 255, 256}
+
+func (interopValue) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(interopValue{randomValue(r, 3)})
+}
+
+func randomValue(r *rand.Rand, depth int) interface{} {
+	kinds := 10
+	if depth == 0 {
+		kinds = 8 // no arrays or maps
+	}
+	switch r.Intn(kinds) {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		// any width, either sign
+		n := r.Int63() >> uint(r.Intn(63))
+		if r.Intn(2) == 0 {
+			n = -n - 1
+		}
+		return n
+	case 3:
+		return r.Uint64() >> uint(r.Intn(64))
+	case 4:
+		return float32(r.NormFloat64() * math.Pow(10, float64(r.Intn(20)-10)))
+	case 5:
+		switch r.Intn(8) {
+		case 0:
+			return math.Inf(1)
+		case 1:
+			return math.NaN()
+		}
+		return r.NormFloat64() * math.Pow(10, float64(r.Intn(200)-100))
+	case 6:
+		runes := make([]rune, randomLength(r))
+		for i := range runes {
+			if r.Intn(4) == 0 {
+				runes[i] = rune(r.Intn(0x10000))
+			} else {
+				runes[i] = rune('a' + r.Intn(26))
+			}
+		}
+		return string(runes)
+	case 7:
+		b := make([]byte, randomLength(r))
+		r.Read(b)
+		return b
+	case 8:
+		arr := make([]interface{}, randomLength(r)%20)
+		for i := range arr {
+			arr[i] = randomValue(r, depth-1)
+		}
+		return arr
+	}
+	m := make(map[string]interface{})
+	for i := randomLength(r) % 20; i > 0; i-- {
+		m[strconv.Itoa(r.Intn(1000))] = randomValue(r, depth-1)
+	}
+	return m
+}
+
+func randomLength(r *rand.Rand) int {
+	if r.Intn(4) == 0 {
+		return interopLengths[r.Intn(len(interopLengths))]
+	}
+	return r.Intn(40)
+}
+
+// Numbers and floats after interopNormal, so that values compare equal
+// whichever type and width they were decoded as
+type (
+	interopNumber string
+	interopFloat  uint64
+)
+
+// Returns @v with every number turned into an interopNumber or
+// interopFloat, every map into a map[string]interface{}, and nil []byte
+// into empty ones
+func interopNormal(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int8:
+		return interopNumber(strconv.FormatInt(int64(x), 10))
+	case int16:
+		return interopNumber(strconv.FormatInt(int64(x), 10))
+	case int32:
+		return interopNumber(strconv.FormatInt(int64(x), 10))
+	case int64:
+		return interopNumber(strconv.FormatInt(x, 10))
+	case uint8:
+		return interopNumber(strconv.FormatUint(uint64(x), 10))
+	case uint16:
+		return interopNumber(strconv.FormatUint(uint64(x), 10))
+	case uint32:
+		return interopNumber(strconv.FormatUint(uint64(x), 10))
+	case uint64:
+		return interopNumber(strconv.FormatUint(x, 10))
+	case float32:
+		return interopFloat(math.Float64bits(float64(x)))
+	case float64:
+		return interopFloat(math.Float64bits(x))
+	case []byte:
+		if x == nil {
+			return []byte{}
+		}
+		return x
+	case []interface{}:
+		values := make([]interface{}, len(x))
+		for i := range x {
+			values[i] = interopNormal(x[i])
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			values[k] = interopNormal(v)
+		}
+		return values
+	case map[interface{}]interface{}:
+		values := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			key, ok := k.(string)
+			if !ok {
+				return x
+			}
+			values[key] = interopNormal(v)
+		}
+		return values
+	}
+	return v
+}
+
+func TestInteropEncode(t *testing.T) {
+	check := func(v interopValue) bool {
+		encoded, err := Marshal(v.V)
+		if err != nil {
+			t.Logf("Marshal failed: %v", err)
+			return false
+		}
+		decoded, err := vmihailenco.NewDecoder(bytes.NewReader(encoded)).DecodeInterface()
+		if err != nil {
+			t.Logf("vmihailenco couldn't decode %x: %v", encoded, err)
+			return false
+		}
+		if !reflect.DeepEqual(interopNormal(decoded), interopNormal(v.V)) {
+			t.Logf("%x decoded by vmihailenco as %#v", encoded, decoded)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInteropDecode(t *testing.T) {
+	check := func(v interopValue) bool {
+		encoded, err := vmihailenco.Marshal(v.V)
+		if err != nil {
+			t.Logf("vmihailenco Marshal failed: %v", err)
+			return false
+		}
+		end, decoded := Decode(&encoded, 0)
+		if end != len(encoded) {
+			t.Logf("Decode of %x ended at %d", encoded, end)
+			return false
+		}
+		if !reflect.DeepEqual(interopNormal(decoded), interopNormal(v.V)) {
+			t.Logf("%x from vmihailenco decoded as %#v", encoded, decoded)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
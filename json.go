@@ -0,0 +1,392 @@
+package msgpack
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Conversion between msgpack and JSON works directly on the bytes and
+// tokens of each format and never builds interface{} values. Since JSON
+// can't express everything msgpack can, the conversion is defined as:
+//
+//   - bin is written as a base64 (standard encoding) JSON string, and so
+//     comes back from FromJSON as str
+//   - ext is written as the object {"$ext": <type>, "$data": "<base64>"},
+//     and FromJSON turns any object whose first key is "$ext" back into ext
+//   - map keys that are numbers, booleans, nil or bin are written as their
+//     JSON text inside a string (1 becomes "1", nil becomes "null"). Keys
+//     that are arrays, maps or ext are an error
+//   - NaN and infinite floats are written as null
+//   - FromJSON encodes numbers without a fraction or exponent as the
+//     smallest int (or uint, past the range of int64) that holds them, and
+//     everything else as float64. A float like 2.0 is written by ToJSON as
+//     2, so it comes back as an int
+
+// Writes each msgpack object in @data to @w as JSON text followed by a
+// newline, the same way a json.Encoder would write them.
+func ToJSON(data []byte, w io.Writer) error {
+	j := &jsonWriter{w: bufio.NewWriter(w), input: data}
+	offset := 0
+	for offset < len(data) {
+		var err error
+		if offset, err = j.value(offset); err != nil {
+			return err
+		}
+		j.w.WriteByte('\n')
+	}
+	return j.w.Flush()
+}
+
+type jsonWriter struct {
+	w       *bufio.Writer
+	input   []byte
+	scratch []byte
+	// arrays and maps the current object is inside of
+	depth int
+}
+
+// Returns the payload bytes of the object at @offset that has header @h
+func (j *jsonWriter) payload(offset int, h Header) []byte {
+	return j.input[offset+h.Size : offset+h.Size+h.Length]
+}
+
+func (j *jsonWriter) float(offset int) {
+	value, _ := parseFloat(&j.input, offset)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		j.w.WriteString("null")
+		return
+	}
+	bits := 64
+	if j.input[offset] == 0xca {
+		bits = 32
+	}
+	j.scratch = strconv.AppendFloat(j.scratch[:0], value, 'g', -1, bits)
+	j.w.Write(j.scratch)
+}
+
+func (j *jsonWriter) base64(raw []byte) {
+	j.w.WriteByte('"')
+	enc := base64.NewEncoder(base64.StdEncoding, j.w)
+	enc.Write(raw)
+	enc.Close()
+	j.w.WriteByte('"')
+}
+
+// Writes the object at @offset and returns the offset following it
+func (j *jsonWriter) value(offset int) (int, error) {
+	h, err := ReadHeader(j.input, offset)
+	if err != nil {
+		return offset, err
+	}
+	if (h.Type == ArrayType || h.Type == MapType) && j.depth >= DEFAULT_MAX_DEPTH {
+		return offset, tooDeepError(offset)
+	}
+	switch h.Type {
+	case NilType:
+		j.w.WriteString("null")
+	case BoolType:
+		j.w.WriteString(strconv.FormatBool(j.input[offset] == 0xc3))
+	case IntType:
+		value, _ := parseInt(&j.input, offset)
+		j.scratch = strconv.AppendInt(j.scratch[:0], value, 10)
+		j.w.Write(j.scratch)
+	case UintType:
+		value, _ := parseUint(&j.input, offset)
+		j.scratch = strconv.AppendUint(j.scratch[:0], value, 10)
+		j.w.Write(j.scratch)
+	case FloatType:
+		j.float(offset)
+	case StrType:
+		writeJSONString(j.w, j.payload(offset, h))
+	case BinType:
+		j.base64(j.payload(offset, h))
+	case ExtType:
+		j.w.WriteString(`{"$ext":`)
+		j.w.WriteString(strconv.Itoa(int(h.Ext)))
+		j.w.WriteString(`,"$data":`)
+		j.base64(j.payload(offset, h))
+		j.w.WriteByte('}')
+	case ArrayType:
+		j.w.WriteByte('[')
+		offset += h.Size
+		j.depth++
+		for i := 0; i < h.Length; i++ {
+			if i > 0 {
+				j.w.WriteByte(',')
+			}
+			if offset, err = j.value(offset); err != nil {
+				return offset, err
+			}
+		}
+		j.w.WriteByte(']')
+		j.depth--
+		return offset, nil
+	case MapType:
+		j.w.WriteByte('{')
+		offset += h.Size
+		j.depth++
+		for i := 0; i < h.Length; i++ {
+			if i > 0 {
+				j.w.WriteByte(',')
+			}
+			if offset, err = j.key(offset); err != nil {
+				return offset, err
+			}
+			j.w.WriteByte(':')
+			if offset, err = j.value(offset); err != nil {
+				return offset, err
+			}
+		}
+		j.w.WriteByte('}')
+		j.depth--
+		return offset, nil
+	}
+	return offset + h.Size + h.Length, nil
+}
+
+// Writes the map key at @offset as a JSON string and returns the offset
+// following it
+func (j *jsonWriter) key(offset int) (int, error) {
+	h, err := ReadHeader(j.input, offset)
+	if err != nil {
+		return offset, err
+	}
+	switch h.Type {
+	case StrType:
+		writeJSONString(j.w, j.payload(offset, h))
+	case BinType:
+		j.base64(j.payload(offset, h))
+	case NilType, BoolType, IntType, UintType:
+		j.w.WriteByte('"')
+		j.value(offset)
+		j.w.WriteByte('"')
+	case FloatType:
+		value, _ := parseFloat(&j.input, offset)
+		j.w.WriteByte('"')
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			j.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		} else {
+			j.float(offset)
+		}
+		j.w.WriteByte('"')
+	default:
+		return offset, &DecodeError{Offset: offset, Msg: fmt.Sprintf("%v can't be a JSON object key", h.Type)}
+	}
+	return offset + h.Size + h.Length, nil
+}
+
+const hexdigits = "0123456789abcdef"
+
+// Writes @s as a quoted JSON string. Invalid UTF-8 is replaced with U+FFFD,
+// like encoding/json does
+func writeJSONString(w *bufio.Writer, s []byte) {
+	w.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			w.Write(s[start:i])
+			switch b {
+			case '"', '\\':
+				w.WriteByte('\\')
+				w.WriteByte(b)
+			case '\n':
+				w.WriteString(`\n`)
+			case '\r':
+				w.WriteString(`\r`)
+			case '\t':
+				w.WriteString(`\t`)
+			default:
+				w.WriteString(`\u00`)
+				w.WriteByte(hexdigits[b>>4])
+				w.WriteByte(hexdigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			w.Write(s[start:i])
+			w.WriteString(`\ufffd`)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	w.Write(s[start:])
+	w.WriteByte('"')
+}
+
+// Reads JSON values from @r until EOF and appends each one to @dst as a
+// msgpack object. Returns the extended slice, which holds whatever was
+// converted before any error. See ToJSON for how values are mapped.
+func FromJSON(r io.Reader, dst []byte) ([]byte, error) {
+	t := &jsonReader{dec: json.NewDecoder(r), buf: dst[:cap(dst)], offset: len(dst)}
+	t.dec.UseNumber()
+	for {
+		err := t.value()
+		if err == io.EOF {
+			return t.buf[:t.offset], nil
+		}
+		if err != nil {
+			return t.buf[:t.offset], err
+		}
+	}
+}
+
+// an array or object that FromJSON hasn't seen the end of yet
+type jsonContainer struct {
+	start int // offset of the space set aside for the header
+	items int // values seen so far; for objects this counts keys too
+	ismap bool
+}
+
+type jsonReader struct {
+	dec    *json.Decoder
+	buf    []byte
+	offset int
+	stack  []jsonContainer
+}
+
+// Makes sure there are at least @n bytes of room past the offset
+func (t *jsonReader) ensure(n int) {
+	if len(t.buf)-t.offset >= n {
+		return
+	}
+	size := 2 * len(t.buf)
+	if size < t.offset+n {
+		size = t.offset + n
+	}
+	buf := make([]byte, size)
+	copy(buf, t.buf[:t.offset])
+	t.buf = buf
+}
+
+// Converts one complete JSON value
+func (t *jsonReader) value() error {
+	depth := len(t.stack)
+	for {
+		tok, err := t.dec.Token()
+		if err != nil {
+			if err == io.EOF && len(t.stack) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if len(t.stack) > 0 {
+			top := &t.stack[len(t.stack)-1]
+			if top.ismap && top.items == 0 && tok == "$ext" {
+				if err = t.ext(top.start); err != nil {
+					return err
+				}
+				t.stack = t.stack[:len(t.stack)-1]
+				goto next
+			}
+			if tok != json.Delim(']') && tok != json.Delim('}') {
+				top.items++
+			}
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			switch tok {
+			case '[', '{':
+				t.ensure(maxContainerHeader)
+				t.stack = append(t.stack, jsonContainer{start: t.offset, ismap: tok == '{'})
+				t.offset += maxContainerHeader
+			case ']', '}':
+				top := t.stack[len(t.stack)-1]
+				count := top.items
+				if top.ismap {
+					count /= 2
+				}
+				t.offset = backpatchHeader(t.buf, top.start, t.offset, count, top.ismap)
+				t.stack = t.stack[:len(t.stack)-1]
+			}
+		case string:
+			t.ensure(5 + len(tok))
+			t.offset = encodeString(t.buf, t.offset, tok)
+		case json.Number:
+			t.ensure(9)
+			t.offset = encodeNumber(t.buf, t.offset, tok)
+		case bool:
+			t.ensure(1)
+			t.offset = encodeBool(t.buf, t.offset, tok)
+		case nil:
+			t.ensure(1)
+			t.offset = encodeNil(t.buf, t.offset)
+		}
+	next:
+		if len(t.stack) == depth {
+			return nil
+		}
+	}
+}
+
+var errJSONExt = errors.New(`msgpack: "$ext" object must look like {"$ext": <type>, "$data": "<base64>"}`)
+
+// Converts the rest of an object whose first key was "$ext" into an ext,
+// writing it at @start in place of the map header set aside there
+func (t *jsonReader) ext(start int) error {
+	tok, err := t.dec.Token()
+	if err != nil {
+		return err
+	}
+	num, ok := tok.(json.Number)
+	if !ok {
+		return errJSONExt
+	}
+	exttype, err := strconv.ParseInt(string(num), 10, 8)
+	if err != nil {
+		return errJSONExt
+	}
+	if tok, err = t.dec.Token(); err != nil {
+		return err
+	}
+	if tok != "$data" {
+		return errJSONExt
+	}
+	if tok, err = t.dec.Token(); err != nil {
+		return err
+	}
+	encoded, ok := tok.(string)
+	if !ok {
+		return errJSONExt
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errJSONExt
+	}
+	if tok, err = t.dec.Token(); err != nil {
+		return err
+	}
+	if tok != json.Delim('}') {
+		return errJSONExt
+	}
+	t.offset = start
+	t.ensure(6 + len(data))
+	t.offset = encodeExt(t.buf, t.offset, int8(exttype), data)
+	return nil
+}
+
+func encodeNumber(buf []byte, offset int, num json.Number) int {
+	if i, err := strconv.ParseInt(string(num), 10, 64); err == nil {
+		return encodeInt(buf, offset, i)
+	}
+	if u, err := strconv.ParseUint(string(num), 10, 64); err == nil {
+		return encodeUint(buf, offset, uint(u))
+	}
+	f, _ := strconv.ParseFloat(string(num), 64)
+	return encodeFloat64(buf, offset, f)
+}
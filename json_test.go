@@ -0,0 +1,125 @@
+package msgpack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, doc := range []string{
+		`null`,
+		`true`,
+		`[1,2,300,70000,5000000000,-2000,18446744073709551615]`,
+		`2.5`,
+		`"a \"quoted\"\n\u0001 string"`,
+		`{"a":1,"b":[true,false,null],"c":{"d":"e"}}`,
+		`[]`,
+		`{}`,
+		`[[[]],{"x":[{}]}]`,
+		`{"$ext":5,"$data":"AQID"}`,
+		`{"$ext":-1,"$data":"AAAAAA=="}`,
+		`"` + strings.Repeat("x", 300) + `"`,
+	} {
+		packed, err := FromJSON(strings.NewReader(doc), nil)
+		if err != nil {
+			t.Errorf("FromJSON(%v) failed: %v", doc, err)
+			continue
+		}
+		var out bytes.Buffer
+		if err = ToJSON(packed, &out); err != nil {
+			t.Errorf("ToJSON of %v failed: %v", doc, err)
+			continue
+		}
+		if out.String() != doc+"\n" {
+			t.Errorf("Round trip should be %v but was %v", doc, out.String())
+		}
+	}
+}
+
+func TestFromJSONArray16(t *testing.T) {
+	doc := `["a","b","c","d","e","f","g","h","i","j","k","l","m","n","o","p"]`
+	packed, err := FromJSON(strings.NewReader(doc), []byte{0xc0})
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+	if len(packed) != 1+3+16*2 {
+		t.Errorf("Encoded length should be %v but is %v", 1+3+16*2, len(packed))
+	}
+	if packed[0] != 0xc0 || packed[1] != 0xdc {
+		t.Errorf("Should be appended as arr16 0xdc but is 0x%x", packed[1])
+	}
+	_, dec := Decode(&packed, 1)
+	if !compareInterfaceStringSlice(dec.([]interface{}), []interface{}{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p"}) {
+		t.Errorf("Decode should be the letters a through p but was %v", dec)
+	}
+}
+
+func TestFromJSONErrors(t *testing.T) {
+	for _, doc := range []string{
+		`[1,2`,
+		`{"a":}`,
+		`{"$ext":"x","$data":""}`,
+		`{"$ext":1,"other":""}`,
+	} {
+		if _, err := FromJSON(strings.NewReader(doc), nil); err == nil {
+			t.Errorf("FromJSON(%v) should have failed", doc)
+		}
+	}
+}
+
+func TestToJSONSpecialValues(t *testing.T) {
+	buf := make([]byte, 100)
+	offset := encodeMapHeader(buf, 0, 4)
+	offset = encodeInt(buf, offset, 1)
+	offset = encodeFloat64(buf, offset, math.NaN())
+	offset = encodeBool(buf, offset, true)
+	offset = encodeFloat64(buf, offset, math.Inf(1))
+	offset = encodeNil(buf, offset)
+	offset = encodeBin(buf, offset, []byte{0xff})
+	offset = encodeBin(buf, offset, []byte("k"))
+	offset = encodeFloat32(buf, offset, 1.5)
+	var out bytes.Buffer
+	if err := ToJSON(buf[:offset], &out); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	expected := `{"1":null,"true":null,"null":"/w==","aw==":1.5}` + "\n"
+	if out.String() != expected {
+		t.Errorf("ToJSON should be %v but was %v", expected, out.String())
+	}
+
+	// a map can't be a key
+	buf = []byte{0x81, 0x80, 0xc0}
+	if err := ToJSON(buf, &out); err == nil {
+		t.Errorf("ToJSON should not accept a map as a key")
+	}
+	// truncated
+	buf = []byte{0x92, 0x01}
+	if err := ToJSON(buf, &out); err == nil {
+		t.Errorf("ToJSON should not accept truncated input")
+	}
+}
+
+func TestToJSONInvalidUTF8(t *testing.T) {
+	buf := []byte{0xa3, 0x61, 0xff, 0x62}
+	var out bytes.Buffer
+	if err := ToJSON(buf, &out); err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if out.String() != `"a\ufffdb"`+"\n" {
+		t.Errorf("ToJSON should replace invalid UTF-8 but was %v", out.String())
+	}
+}
+
+func TestToJSONTooDeep(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x91}, DEFAULT_MAX_DEPTH+1)
+	deep = append(deep, 0xc0)
+	if err := ToJSON(deep, ioutil.Discard); err == nil {
+		t.Errorf("ToJSON should refuse arrays nested deeper than DEFAULT_MAX_DEPTH")
+	}
+	if err := ToJSON(deep[1:], ioutil.Discard); err != nil {
+		t.Errorf("ToJSON should allow arrays nested DEFAULT_MAX_DEPTH deep: %v", err)
+	}
+}
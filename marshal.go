@@ -0,0 +1,333 @@
+package msgpack
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// Types doEncode doesn't switch on are encoded here by walking them with
+// reflect. Structs become maps keyed by field name, or arrays of their
+// fields in order if the struct has a field
+//
+//	_ struct{} `msgpack:",asArray"`
+//
+// or the Encoder has StructAsArray set. Field tags work as they do for
+// encoding/json:
+//
+//	`msgpack:"name"`        the key to use instead of the field name
+//	`msgpack:"-"`           leave the field out
+//	`msgpack:",omitempty"`  leave the field out if it is the zero value for
+//	                        its type, or has an IsZero method that returns
+//	                        true. Ignored for asArray structs, which need
+//	                        every position filled
+//	`msgpack:",inline"`     the field is a struct, or pointer to one, whose
+//	                        fields are written as if they were the outer
+//	                        struct's. Fields of a nil pointer are left out
+//	`msgpack:",remain"`     the field is a map[string]Raw holding keys that
+//	                        matched no field when decoding, which are
+//	                        written back out alongside the fields
+//
+// Types that know how to encode themselves for
+// gopkg.in/vmihailenco/msgpack.v2, and time.Time, are still handed to it.
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	marshalerType     = reflect.TypeOf((*msgpack.Marshaler)(nil)).Elem()
+	customEncoderType = reflect.TypeOf((*msgpack.CustomEncoder)(nil)).Elem()
+)
+
+// How a type encodes itself for vmihailenco, if it does
+type selfEncoding struct {
+	direct bool // the type has the methods, or is time.Time
+	ptr    bool // a pointer to the type has them
+}
+
+// reflect.Type -> selfEncoding
+var selfEncodings sync.Map
+
+func selfEncodingFor(t reflect.Type) selfEncoding {
+	if cached, found := selfEncodings.Load(t); found {
+		return cached.(selfEncoding)
+	}
+	pt := reflect.PtrTo(t)
+	self := selfEncoding{
+		direct: t == timeType || t.Implements(marshalerType) || t.Implements(customEncoderType),
+		ptr:    pt.Implements(marshalerType) || pt.Implements(customEncoderType),
+	}
+	selfEncodings.Store(t, self)
+	return self
+}
+
+// Returns true if @v, which encodes itself as @self says, should be left to
+// vmihailenco. Nil pointers are written as nil without asking it
+func (self selfEncoding) applies(v reflect.Value) bool {
+	return (self.direct || self.ptr) && !(v.Kind() == reflect.Ptr && v.IsNil())
+}
+
+// Returns what to hand vmihailenco for @v: @v itself, or a pointer to it if
+// only the pointer has the methods. A @v that isn't addressable, such as a
+// map value or a struct passed to Marshal by value, is copied to get one
+func (self selfEncoding) target(v reflect.Value) interface{} {
+	if self.direct {
+		return v.Interface()
+	}
+	if !v.CanAddr() {
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		v = copied
+	}
+	return v.Addr().Interface()
+}
+
+func unsupportedTypeError(t reflect.Type) error {
+	return fmt.Errorf("msgpack: unsupported type %v", t)
+}
+
+// Returns an upper bound on the bytes encodeValue will use for @v, or an
+// error if it can't be encoded
+func (enc *Encoder) maxValueSize(v reflect.Value) (int, error) {
+	if !v.IsValid() {
+		return 1, nil
+	}
+	return enc.maxTypedSize(v, selfEncodingFor(v.Type()))
+}
+
+// maxValueSize, knowing how @v's type encodes itself
+func (enc *Encoder) maxTypedSize(v reflect.Value, self selfEncoding) (int, error) {
+	if self.applies(v) {
+		// its size isn't known without encoding it, so encodeSelf makes
+		// room for it instead
+		return 0, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 1, nil
+		}
+		if v.Kind() == reflect.Interface {
+			return enc.maxEncodedSize(v.Elem().Interface())
+		}
+		return enc.maxValueSize(v.Elem())
+	case reflect.Bool:
+		return 1, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return 9, nil
+	case reflect.String:
+		return enc.maxEncodedSize(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return 1, nil
+		}
+		if v.Type() == rawType {
+			return 1 + v.Len(), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return 5 + v.Len(), nil
+		}
+		size := maxContainerHeader
+		for i := 0; i < v.Len(); i++ {
+			elemsize, err := enc.maxValueSize(v.Index(i))
+			if err != nil {
+				return 0, err
+			}
+			size += elemsize
+		}
+		return size, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return 1, nil
+		}
+		size := maxContainerHeader
+		iter := v.MapRange()
+		for iter.Next() {
+			ksize, err := enc.maxValueSize(iter.Key())
+			if err != nil {
+				return 0, err
+			}
+			vsize, err := enc.maxValueSize(iter.Value())
+			if err != nil {
+				return 0, err
+			}
+			size += ksize + vsize
+		}
+		return size, nil
+	case reflect.Struct:
+		plan := planFor(v.Type())
+		// every key is counted, in case it is written
+		size := maxContainerHeader + plan.keysize
+		for _, field := range plan.fields {
+			fv, ok := fieldToEncode(v, field.index)
+			if !ok {
+				continue
+			}
+			fsize, err := enc.maxTypedSize(fv, field.self)
+			if err != nil {
+				return 0, err
+			}
+			size += fsize
+		}
+		if remain, ok := plan.remainValue(v); ok {
+			rsize, err := enc.maxValueSize(remain)
+			if err != nil {
+				return 0, err
+			}
+			size += rsize
+		}
+		return size, nil
+	}
+	return 0, unsupportedTypeError(v.Type())
+}
+
+// Encodes @v, which maxValueSize has accepted
+func (enc *Encoder) encodeValue(ret *[]byte, offset int, v reflect.Value) int {
+	if !v.IsValid() {
+		return encodeNil(*ret, offset)
+	}
+	return enc.encodeTyped(ret, offset, v, selfEncodingFor(v.Type()))
+}
+
+// encodeValue, knowing how @v's type encodes itself
+func (enc *Encoder) encodeTyped(ret *[]byte, offset int, v reflect.Value, self selfEncoding) int {
+	if self.applies(v) {
+		return encodeSelf(ret, offset, self.target(v))
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return encodeNil(*ret, offset)
+		}
+		if v.Kind() == reflect.Interface {
+			return enc.doEncode(v.Elem().Interface(), ret, offset)
+		}
+		return enc.encodeValue(ret, offset, v.Elem())
+	case reflect.Bool:
+		return encodeBool(*ret, offset, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeInt(*ret, offset, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return encodeUint(*ret, offset, uint(v.Uint()))
+	case reflect.Float32:
+		return encodeFloat32(*ret, offset, float32(v.Float()))
+	case reflect.Float64:
+		return encodeFloat64(*ret, offset, v.Float())
+	case reflect.String:
+		return enc.encodeString(*ret, offset, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return encodeNil(*ret, offset)
+		}
+		if v.Type() == rawType {
+			return encodeRaw(*ret, offset, v.Bytes())
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return encodeBin(*ret, offset, b)
+		}
+		offset = encodeArrayHeader(*ret, offset, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			offset = enc.encodeValue(ret, offset, v.Index(i))
+		}
+		return offset
+	case reflect.Map:
+		if v.IsNil() {
+			return encodeNil(*ret, offset)
+		}
+		offset = encodeMapHeader(*ret, offset, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			offset = enc.encodeValue(ret, offset, iter.Key())
+			offset = enc.encodeValue(ret, offset, iter.Value())
+		}
+		return offset
+	case reflect.Struct:
+		return enc.encodeStruct(ret, offset, v)
+	}
+	// maxValueSize refuses anything else, so only Encode, which doesn't
+	// call it, gets here
+	panic(encodeFailure{unsupportedTypeError(v.Type())})
+}
+
+func (enc *Encoder) encodeStruct(ret *[]byte, offset int, v reflect.Value) int {
+	plan := planFor(v.Type())
+	if enc.StructAsArray || plan.asArray {
+		offset = encodeArrayHeader(*ret, offset, len(plan.fields))
+		for _, field := range plan.fields {
+			fv, ok := fieldToEncode(v, field.index)
+			if !ok {
+				offset = encodeNil(*ret, offset)
+				continue
+			}
+			offset = enc.encodeTyped(ret, offset, fv, field.self)
+		}
+		return offset
+	}
+
+	count := len(plan.fields)
+	if plan.optional {
+		count = 0
+		for _, field := range plan.fields {
+			if _, ok := field.value(v); ok {
+				count++
+			}
+		}
+	}
+	remain, hasremain := plan.remainValue(v)
+	if hasremain {
+		for _, key := range remain.MapKeys() {
+			if _, known := plan.byname[key.String()]; !known {
+				count++
+			}
+		}
+	}
+	offset = encodeMapHeader(*ret, offset, count)
+	for _, field := range plan.fields {
+		fv, ok := field.value(v)
+		if !ok {
+			continue
+		}
+		offset += copy((*ret)[offset:], field.key)
+		offset = enc.encodeTyped(ret, offset, fv, field.self)
+	}
+	if hasremain {
+		iter := remain.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if _, known := plan.byname[key]; known {
+				continue
+			}
+			offset = enc.encodeString(*ret, offset, key)
+			offset = encodeRaw(*ret, offset, iter.Value().Bytes())
+		}
+	}
+	return offset
+}
+
+// Returns the unknown keys the struct @v collected when it was decoded, to
+// be written back out, and false if there are none
+func (plan *structPlan) remainValue(v reflect.Value) (reflect.Value, bool) {
+	if plan.remain == nil {
+		return reflect.Value{}, false
+	}
+	remain, ok := fieldToEncode(v, plan.remain)
+	if !ok || remain.Len() == 0 {
+		return reflect.Value{}, false
+	}
+	return remain, true
+}
+
+// Returns the field's value in the struct @v, and whether it is written
+// when the struct is a map
+func (field *structField) value(v reflect.Value) (reflect.Value, bool) {
+	fv, ok := fieldToEncode(v, field.index)
+	if !ok || field.omitEmpty && isEmptyValue(fv) {
+		return fv, false
+	}
+	return fv, true
+}
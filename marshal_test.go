@@ -0,0 +1,278 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	vmihailenco "gopkg.in/vmihailenco/msgpack.v2"
+)
+
+type point struct {
+	_     struct{} `msgpack:",asArray"`
+	X, Y  int
+	Label string
+}
+
+// a later version of point
+type pointV2 struct {
+	_     struct{} `msgpack:",asArray"`
+	X, Y  int
+	Label string
+	Z     int
+}
+
+type marshalTarget struct {
+	Name   string `msgpack:"name"`
+	Points []point
+	Lookup map[string]uint16
+	Ptr    *float64
+	Skip   int `msgpack:"-"`
+	hidden int
+}
+
+func TestMarshalStruct(t *testing.T) {
+	f := 2.5
+	val := marshalTarget{
+		Name:   "shape",
+		Points: []point{{X: 1, Y: 2, Label: "a"}},
+		Lookup: map[string]uint16{"k": 300},
+		Ptr:    &f,
+		Skip:   7,
+		hidden: 8,
+	}
+	encoded, err := Marshal(val)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	_, decoded := Decode(&encoded, 0)
+	expected := map[string]interface{}{
+		"name":   "shape",
+		"Points": []interface{}{[]interface{}{int64(1), int64(2), "a"}},
+		"Lookup": map[string]interface{}{"k": uint64(300)},
+		"Ptr":    2.5,
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Decoded %#v, want %#v", decoded, expected)
+	}
+
+	var back marshalTarget
+	if err = Unmarshal(encoded, &back); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	val.Skip, val.hidden = 0, 0
+	if !reflect.DeepEqual(back, val) {
+		t.Errorf("Round trip gave %+v, want %+v", back, val)
+	}
+}
+
+func TestMarshalAsArray(t *testing.T) {
+	encoded, err := Marshal(point{X: 1, Y: 300, Label: "p"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := []byte{0x93, 0x1, 0xd1, 0x1, 0x2c, 0xa1, 0x70}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encoded % x, want % x", encoded, expected)
+	}
+
+	// newer data with an extra field decodes into the old struct
+	newer, _ := Marshal(pointV2{X: 1, Y: 2, Label: "q", Z: 3})
+	var old point
+	if err = Unmarshal(newer, &old); err != nil || old.X != 1 || old.Y != 2 || old.Label != "q" {
+		t.Errorf("Unmarshal of a longer array gave %+v (%v)", old, err)
+	}
+	// and older data into the new one, leaving the missing field alone
+	v2 := pointV2{Z: 9}
+	if err = Unmarshal(encoded, &v2); err != nil || v2.Y != 300 || v2.Z != 9 {
+		t.Errorf("Unmarshal of a shorter array gave %+v (%v)", v2, err)
+	}
+}
+
+func TestEncoderStructAsArray(t *testing.T) {
+	type pair struct {
+		A string
+		B bool
+	}
+	enc := &Encoder{StructAsArray: true}
+	encoded, err := enc.Marshal(pair{"x", true})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := []byte{0x92, 0xa1, 0x78, 0xc3}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encoded % x, want % x", encoded, expected)
+	}
+	var back pair
+	if err = Unmarshal(encoded, &back); err != nil || back != (pair{"x", true}) {
+		t.Errorf("Unmarshal gave %+v (%v)", back, err)
+	}
+}
+
+func TestMarshalFallback(t *testing.T) {
+	// time.Time is still encoded and decoded by vmihailenco
+	type event struct {
+		When  time.Time
+		Times map[string]time.Time
+	}
+	when := time.Unix(1500000000, 12345)
+	encoded, err := Marshal(event{When: when, Times: map[string]time.Time{"then": when.Add(time.Hour)}})
+	if err != nil {
+		t.Fatalf("Marshal of a time failed: %v", err)
+	}
+	var back event
+	if err = Unmarshal(encoded, &back); err != nil {
+		t.Fatalf("Unmarshal of a time failed: %v", err)
+	}
+	if !back.When.Equal(when) || !back.Times["then"].Equal(when.Add(time.Hour)) {
+		t.Errorf("Times came back as %v and %v", back.When, back.Times)
+	}
+	if err = Unmarshal([]byte{0x81, 0xa4, 0x57, 0x68, 0x65, 0x6e, 0xa1, 0x78}, &back); err == nil {
+		t.Errorf("Unmarshal of a str into a time should fail")
+	}
+	if _, err := Marshal(struct{ C chan int }{}); err == nil {
+		t.Errorf("Marshal of a channel should fail")
+	}
+}
+
+// encodes itself for vmihailenco, but only through a pointer
+type ptrMarshaler struct {
+	N int
+}
+
+func (p *ptrMarshaler) MarshalMsgpack() ([]byte, error) {
+	return vmihailenco.Marshal(map[string]int{"n": p.N})
+}
+
+func TestMarshalPointerReceiver(t *testing.T) {
+	type outer struct {
+		P ptrMarshaler
+	}
+	expected := []byte{0x81, 0xa1, 0x50, 0x81, 0xa1, 0x6e, 0x7}
+	for _, v := range []interface{}{outer{P: ptrMarshaler{7}}, &outer{P: ptrMarshaler{7}}, map[string]ptrMarshaler{"P": {7}}} {
+		encoded, err := Marshal(v)
+		if err != nil || !bytes.Equal(encoded, expected) {
+			t.Errorf("Marshal(%#v) = % x (%v), want % x", v, encoded, err, expected)
+		}
+	}
+	// the sizing pass doesn't count what vmihailenco writes, so Append
+	// has to make room for it
+	many := make([]ptrMarshaler, 100)
+	encoded, err := Append(make([]byte, 0, 1), many)
+	if err != nil || len(encoded) != 3+100*4 {
+		t.Errorf("Append of %d marshalers gave %d bytes (%v)", len(many), len(encoded), err)
+	}
+}
+
+func (p *ptrMarshaler) UnmarshalMsgpack(b []byte) error {
+	var m map[string]int
+	if err := vmihailenco.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	p.N = m["n"]
+	return nil
+}
+
+func TestUnmarshalSelfDecoding(t *testing.T) {
+	encoded, err := Marshal(map[string][]ptrMarshaler{"P": {{3}, {4}}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var back map[string][]ptrMarshaler
+	if err = Unmarshal(encoded, &back); err != nil || len(back["P"]) != 2 || back["P"][1].N != 4 {
+		t.Errorf("Unmarshal gave %v (%v)", back, err)
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	buf := make([]byte, 16)
+	if _, err := Encode([]interface{}{1, make(chan int)}, &buf); err == nil {
+		t.Errorf("Encode of a chan should fail")
+	}
+}
+
+type celsius struct {
+	Degrees float64
+	Valid   bool
+}
+
+// IsZero reports an invalid reading, whatever its degrees
+func (c celsius) IsZero() bool {
+	return !c.Valid
+}
+
+type audit struct {
+	Created string `msgpack:"created"`
+	Updated string `msgpack:"updated,omitempty"`
+}
+
+type base struct {
+	ID   int
+	Name string // hidden by document.Name
+}
+
+type document struct {
+	base   `msgpack:",inline"`
+	Audit  *audit `msgpack:",inline"`
+	Name   string
+	Temp   celsius        `msgpack:",omitempty"`
+	Tags   []string       `msgpack:"tags,omitempty"`
+	Extra  map[string]int `msgpack:",omitempty"`
+	Count  int            `msgpack:",omitempty"`
+	Secret string         `msgpack:"-"`
+}
+
+func TestMarshalTags(t *testing.T) {
+	doc := document{
+		base:   base{ID: 4, Name: "inner"},
+		Audit:  &audit{Created: "today"},
+		Name:   "outer",
+		Temp:   celsius{Degrees: 12},
+		Tags:   []string{},
+		Secret: "s",
+	}
+	encoded, err := Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	_, decoded := Decode(&encoded, 0)
+	expected := map[string]interface{}{
+		"ID":      int64(4),
+		"created": "today",
+		"Name":    "outer",
+		"tags":    []interface{}{}, // empty but not the zero value
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Decoded %#v, want %#v", decoded, expected)
+	}
+
+	// no Audit, so none of its fields
+	doc.Audit = nil
+	doc.Temp.Valid = true
+	encoded, _ = Marshal(doc)
+	_, decoded = Decode(&encoded, 0)
+	m := decoded.(map[string]interface{})
+	if _, found := m["created"]; found || m["Temp"] == nil {
+		t.Errorf("Decoded %#v, want no created and a Temp", m)
+	}
+}
+
+func TestUnmarshalInline(t *testing.T) {
+	encoded, _ := Marshal(map[string]interface{}{
+		"ID":      7,
+		"Name":    "outer",
+		"created": "then",
+		"updated": "now",
+	})
+	var doc document
+	if err := Unmarshal(encoded, &doc); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if doc.ID != 7 || doc.Name != "outer" || doc.base.Name != "" {
+		t.Errorf("Unmarshal gave %+v", doc)
+	}
+	if doc.Audit == nil || *doc.Audit != (audit{"then", "now"}) {
+		t.Errorf("Unmarshal should allocate Audit and fill it in, got %+v", doc.Audit)
+	}
+}
@@ -0,0 +1,334 @@
+// Package msgpacklog stores a stream of msgpack messages in an append-only
+// file.
+//
+// The file starts with an 8 byte magic string, followed by one record per
+// message. Records are frames as written by the framing package with a
+// Fixed32 prefix and a CRC32 trailer:
+//
+//	[4 byte big-endian length][msgpack message][4 byte CRC32 of the message]
+//
+// Each record is written with a single write, but a crash can still leave
+// the last one partly written. Open finds the first record that is cut
+// short, fails its checksum or doesn't hold exactly one msgpack object that
+// msgpack.Decode accepts, and truncates the file there, so the log always
+// ends on a whole record. If reading the file fails, Open returns the error
+// and leaves the file as it is.
+package msgpacklog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gtfierro/msgpack"
+	"github.com/gtfierro/msgpack/framing"
+)
+
+const magic = "MPKLOG01"
+
+// bytes a record takes up on top of its message
+const recordOverhead = 8
+
+const DEFAULT_INDEX_INTERVAL = 1024
+
+// Settings for Open. Fields left at 0 get their defaults
+type Options struct {
+	// the offset of every IndexInterval'th record is kept in memory so
+	// that reading can start at any record without scanning the whole
+	// file. Defaults to DEFAULT_INDEX_INTERVAL
+	IndexInterval int
+	// appends of longer messages are refused. Records already in the log
+	// are read whatever their size. Defaults to
+	// framing.DEFAULT_MAX_FRAME_SIZE
+	MaxRecordSize int
+}
+
+var (
+	ErrNotLog = errors.New("msgpacklog: file is not a msgpack log")
+	ErrClosed = errors.New("msgpacklog: log is closed")
+)
+
+// A Log is an open log file. It is safe for concurrent use
+type Log struct {
+	mu       sync.Mutex
+	f        *os.File
+	writer   *framing.Writer
+	count    int
+	size     int64   // offset of the end of the last record
+	index    []int64 // offsets of records 0, IndexInterval, 2*IndexInterval...
+	interval int
+	dropped  int64
+}
+
+// Opens the log at @path, creating it if it doesn't exist, and truncates
+// any torn or corrupt records off its end. Reads the whole file to check
+// every record. @opts may be nil for the defaults.
+func Open(path string, opts *Options) (*Log, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.IndexInterval <= 0 {
+		o.IndexInterval = DEFAULT_INDEX_INTERVAL
+	}
+	if o.MaxRecordSize <= 0 {
+		o.MaxRecordSize = framing.DEFAULT_MAX_FRAME_SIZE
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{f: f, interval: o.IndexInterval}
+	if err = l.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(l.size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.writer = framing.NewWriter(f)
+	l.writer.Prefix = framing.Fixed32
+	l.writer.Checksum = true
+	l.writer.MaxFrameSize = o.MaxRecordSize
+	return l, nil
+}
+
+// Scans the file, building the index and cutting off anything after the
+// last good record
+func (l *Log) recover() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(magic))
+	n, err := io.ReadFull(l.f, header)
+	switch {
+	case n == len(magic) && string(header) == magic:
+	case n < len(magic) && bytes.HasPrefix([]byte(magic), header[:n]):
+		// new, or torn while being created
+		if err = l.f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = l.f.WriteAt([]byte(magic), 0); err != nil {
+			return err
+		}
+		l.size = int64(len(magic))
+		return l.f.Sync()
+	default:
+		return ErrNotLog
+	}
+
+	l.size = int64(len(magic))
+	reader := newRecordReader(l.f, l.size, info.Size())
+	for {
+		message, err := reader.ReadFrame()
+		if err == nil {
+			if checkRecord(message) == nil {
+				l.added(int64(len(message)))
+				continue
+			}
+			err = framing.ErrTrailingBytes
+		}
+		switch err {
+		case io.EOF:
+			return nil
+		case io.ErrUnexpectedEOF, framing.ErrFrameTooLarge, framing.ErrChecksum, framing.ErrTrailingBytes:
+			// a torn or corrupt record: everything from here on is lost.
+			// ErrFrameTooLarge means its length runs past the end of the
+			// file
+			l.dropped = info.Size() - l.size
+			if err = l.f.Truncate(l.size); err != nil {
+				return err
+			}
+			return l.f.Sync()
+		default:
+			// couldn't read the file, which says nothing about what is in it
+			return err
+		}
+	}
+}
+
+// Returns a reader for the records between offsets @start and @end. Records
+// already in the file are read whatever their size, so the only limit is
+// that a record can't run past @end
+func newRecordReader(f *os.File, start, end int64) *framing.Reader {
+	reader := framing.NewReader(io.NewSectionReader(f, start, end-start))
+	reader.Prefix = framing.Fixed32
+	reader.Checksum = true
+	reader.MaxFrameSize = int(end - start)
+	return reader
+}
+
+// Returns an error unless @message is exactly one object that Decode, and
+// so Iterator.Next, can decode
+func checkRecord(message []byte) error {
+	end, _, err := msgpack.TryDecode(&message, 0)
+	if err != nil {
+		return err
+	}
+	if end != len(message) {
+		return framing.ErrTrailingBytes
+	}
+	return nil
+}
+
+// Notes a new record with a message of @length bytes at the end of the log
+func (l *Log) added(length int64) {
+	if l.count%l.interval == 0 {
+		l.index = append(l.index, l.size)
+	}
+	l.count++
+	l.size += length + recordOverhead
+}
+
+// Returns how many bytes of torn or corrupt records Open cut off the end
+// of the file
+func (l *Log) Dropped() int64 {
+	return l.dropped
+}
+
+// Returns the number of records in the log
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Encodes @v with msgpack and adds it to the end of the log. Returns its
+// record number, counting from 0
+func (l *Log) Append(v interface{}) (int, error) {
+	message, err := msgpack.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return l.AppendRaw(message)
+}
+
+// Adds @message, which must be exactly one msgpack object that Decode can
+// decode (so no ext), to the end of the log. Returns its record number,
+// counting from 0
+func (l *Log) AppendRaw(message []byte) (int, error) {
+	if err := checkRecord(message); err != nil {
+		return 0, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, ErrClosed
+	}
+	if err := l.writer.WriteFrame(message); err != nil {
+		// don't leave part of a record behind for later appends to follow
+		l.f.Truncate(l.size)
+		l.f.Seek(l.size, io.SeekStart)
+		return 0, err
+	}
+	l.added(int64(len(message)))
+	return l.count - 1, nil
+}
+
+// Commits the log to stable storage
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	return l.f.Sync()
+}
+
+// Syncs and closes the log. Iterators can't be used after this
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ErrClosed
+	}
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// Returns an Iterator over the records from number @n up to the end of the
+// log as it is now. Records appended later are not included.
+func (l *Log) Iter(n int) (*Iterator, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil, ErrClosed
+	}
+	if n < 0 {
+		n = 0
+	}
+	it := &Iterator{n: n - 1}
+	if n >= l.count {
+		it.done = true
+		return it, nil
+	}
+	// start at the closest indexed record at or before n
+	slot := sort.Search(len(l.index), func(i int) bool { return i*l.interval > n }) - 1
+	it.reader = newRecordReader(l.f, l.index[slot], l.size)
+	for skip := n - slot*l.interval; skip > 0; skip-- {
+		if _, err := it.reader.ReadFrame(); err != nil {
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+// An Iterator reads records in order. An Iterator is not safe for
+// concurrent use, but any number can be open on one Log.
+//
+//	it, err := log.Iter(0)
+//	for it.Next() {
+//		use(it.Value())
+//	}
+//	if it.Err() != nil { ... }
+type Iterator struct {
+	reader *framing.Reader
+	n      int
+	value  interface{}
+	err    error
+	done   bool
+}
+
+// Moves on to the next record, decoding it with msgpack.Decode. Returns
+// false when there are no more records or there was an error
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+	value, err := it.reader.ReadMessage()
+	if err != nil {
+		it.done = true
+		if err != io.EOF {
+			it.err = err
+		}
+		it.value = nil
+		return false
+	}
+	it.n++
+	it.value = value
+	return true
+}
+
+// Returns the decoded value of the current record
+func (it *Iterator) Value() interface{} {
+	return it.value
+}
+
+// Returns the number of the current record
+func (it *Iterator) Index() int {
+	return it.n
+}
+
+// Returns the error that stopped Next, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
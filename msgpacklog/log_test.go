@@ -0,0 +1,224 @@
+package msgpacklog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gtfierro/msgpack/framing"
+)
+
+func openLog(t *testing.T, path string, opts *Options) *Log {
+	l, err := Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return l
+}
+
+func readAll(t *testing.T, l *Log, n int) []interface{} {
+	it, err := l.Iter(n)
+	if err != nil {
+		t.Fatalf("Iter(%d) failed: %v", n, err)
+	}
+	var values []interface{}
+	for it.Next() {
+		if it.Index() != n+len(values) {
+			t.Errorf("Index() = %d, want %d", it.Index(), n+len(values))
+		}
+		values = append(values, it.Value())
+	}
+	if it.Err() != nil {
+		t.Fatalf("iterating failed: %v", it.Err())
+	}
+	return values
+}
+
+func TestAppendAndIterate(t *testing.T) {
+	opts := &Options{IndexInterval: 4}
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	l := openLog(t, path, opts)
+	var want []interface{}
+	for i := 0; i < 23; i++ {
+		v := map[string]interface{}{"seq": int64(i), "name": "event"}
+		n, err := l.Append(v)
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		if n != i {
+			t.Errorf("Append returned record %d, want %d", n, i)
+		}
+		want = append(want, v)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	l = openLog(t, path, opts)
+	defer l.Close()
+	if l.Len() != len(want) || l.Dropped() != 0 {
+		t.Fatalf("reopened log has %d records and dropped %d bytes, want %d and 0", l.Len(), l.Dropped(), len(want))
+	}
+	for _, n := range []int{0, 1, 3, 4, 5, 16, 22, 23, 40} {
+		got := readAll(t, l, n)
+		var expected []interface{}
+		if n < len(want) {
+			expected = want[n:]
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Iter(%d) read %v, want %v", n, got, expected)
+		}
+	}
+}
+
+func TestIterSnapshot(t *testing.T) {
+	l := openLog(t, filepath.Join(t.TempDir(), "events.log"), nil)
+	defer l.Close()
+	l.Append("a")
+	it, err := l.Iter(0)
+	if err != nil {
+		t.Fatalf("Iter failed: %v", err)
+	}
+	l.Append("b")
+	if !it.Next() || it.Value() != "a" {
+		t.Fatalf("first record is %v, want a", it.Value())
+	}
+	if it.Next() {
+		t.Errorf("iterator saw %v, appended after it was made", it.Value())
+	}
+}
+
+func TestRecoverTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path, nil)
+	for _, v := range []string{"one", "two", "three"} {
+		if _, err := l.Append(v); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	l.Close()
+
+	info, _ := os.Stat(path)
+	// cut the last record short, as if the process died while writing it
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	l = openLog(t, path, nil)
+	if l.Len() != 2 {
+		t.Fatalf("recovered %d records, want 2", l.Len())
+	}
+	if l.Dropped() != 4+6+4-3 {
+		t.Errorf("dropped %d bytes, want %d", l.Dropped(), 4+6+4-3)
+	}
+	if _, err := l.Append("four"); err != nil {
+		t.Fatalf("Append after recovery failed: %v", err)
+	}
+	l.Close()
+
+	l = openLog(t, path, nil)
+	defer l.Close()
+	want := []interface{}{"one", "two", "four"}
+	if got := readAll(t, l, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+}
+
+func TestRecoverBadChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path, nil)
+	l.Append("one")
+	l.Append("two")
+	l.Close()
+
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	// zeros left behind by a filesystem that grew the file before the crash
+	data = append(data, make([]byte, 16)...)
+	os.WriteFile(path, data, 0644)
+
+	l = openLog(t, path, nil)
+	defer l.Close()
+	want := []interface{}{"one"}
+	if got := readAll(t, l, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(magic)+12) {
+		t.Errorf("file is %d bytes after recovery, want %d", info.Size(), len(magic)+12)
+	}
+}
+
+func TestNotLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "other")
+	os.WriteFile(path, []byte("some other file"), 0644)
+	if _, err := Open(path, nil); err != ErrNotLog {
+		t.Errorf("Open returned %v, want ErrNotLog", err)
+	}
+}
+
+func TestAppendRawChecksMessage(t *testing.T) {
+	l := openLog(t, filepath.Join(t.TempDir(), "events.log"), nil)
+	defer l.Close()
+	for _, message := range [][]byte{{}, {0x92, 0x01}, {0x01, 0x02}, {0xd4, 0x05, 0x01}} {
+		if _, err := l.AppendRaw(message); err == nil {
+			t.Errorf("AppendRaw(% x) succeeded", message)
+		}
+	}
+	if l.Len() != 0 {
+		t.Errorf("log has %d records, want 0", l.Len())
+	}
+}
+
+func TestReopenWithSmallerMaxRecordSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path, nil)
+	want := []interface{}{"one", string(make([]byte, 100)), "three"}
+	for _, v := range want {
+		if _, err := l.Append(v); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	l.Close()
+
+	l = openLog(t, path, &Options{MaxRecordSize: 10})
+	defer l.Close()
+	if l.Len() != 3 || l.Dropped() != 0 {
+		t.Fatalf("reopened log has %d records and dropped %d bytes, want 3 and 0", l.Len(), l.Dropped())
+	}
+	if got := readAll(t, l, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+	if _, err := l.Append(string(make([]byte, 100))); err == nil {
+		t.Errorf("Append of a message over MaxRecordSize succeeded")
+	}
+}
+
+func TestRecoverUndecodableRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	l := openLog(t, path, nil)
+	l.Append("one")
+	l.Close()
+
+	// a record that passes its checksum but that Iterator.Next couldn't
+	// decode
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := framing.NewWriter(f)
+	w.Prefix = framing.Fixed32
+	w.Checksum = true
+	w.WriteFrame([]byte{0xd4, 0x05, 0x01})
+	f.Close()
+
+	l = openLog(t, path, nil)
+	defer l.Close()
+	if l.Len() != 1 || l.Dropped() != 4+3+4 {
+		t.Errorf("recovered %d records and dropped %d bytes, want 1 and %d", l.Len(), l.Dropped(), 4+3+4)
+	}
+	want := []interface{}{"one"}
+	if got := readAll(t, l, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+// Package netrpc lets the standard net/rpc package use msgpack instead of
+// gob, so Go services built on net/rpc can talk to non-Go peers.
+//
+// Messages use the MessagePack-RPC layout, with the request or reply body
+// as the only parameter or as the result:
+//
+//	request   [0, seq, "Service.Method", [args]]
+//	response  [1, seq, error, reply]
+//
+// where error is nil on success and a string otherwise. Bodies are encoded
+// with msgpack.Marshal and decoded with msgpack.Unmarshal, so args and
+// reply structs are written as maps keyed by field name (or `msgpack` tag).
+package netrpc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+
+	"github.com/gtfierro/msgpack"
+)
+
+const (
+	requestType  = 0
+	responseType = 1
+)
+
+// messages bigger than this are refused
+const DEFAULT_MAX_MESSAGE_SIZE = 16 * 1024 * 1024
+
+var errMalformed = errors.New("netrpc: malformed message")
+
+// The reading and writing shared by both codecs
+type codec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	// the last message read, and where its body starts. -1 if it has none
+	message []byte
+	body    int
+}
+
+func newCodec(conn io.ReadWriteCloser) codec {
+	return codec{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+// Reads the next message, checking it is an array with @length elements
+// whose first is @msgtype. Returns the offset of the second element
+func (c *codec) read(msgtype int64, length int) (int, error) {
+	var err error
+	if c.message, err = msgpack.ReadObject(c.r, c.message[:0], DEFAULT_MAX_MESSAGE_SIZE); err != nil {
+		return 0, err
+	}
+	h, err := msgpack.ReadHeader(c.message, 0)
+	if err != nil {
+		return 0, err
+	}
+	if h.Type != msgpack.ArrayType || h.Length != length {
+		return 0, errMalformed
+	}
+	offset, value, err := c.decode(h.Size)
+	if err != nil {
+		return 0, err
+	}
+	if toUint64(value) != uint64(msgtype) {
+		return 0, errMalformed
+	}
+	return offset, nil
+}
+
+// Decodes the element of the last message at @offset. Returns errMalformed
+// for anything Decode refuses, like ext or non-str map keys
+func (c *codec) decode(offset int) (int, interface{}, error) {
+	end, value := msgpack.Decode(&c.message, offset)
+	if end == offset {
+		return offset, nil, errMalformed
+	}
+	return end, value, nil
+}
+
+func (c *codec) write(message ...interface{}) error {
+	b, err := msgpack.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err = c.w.Write(b); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// Decodes the body of the last message into @body, or skips it if @body is nil
+func (c *codec) readBody(body interface{}) error {
+	if body == nil || c.body < 0 {
+		return nil
+	}
+	end, err := msgpack.Skip(c.message, c.body)
+	if err != nil {
+		return err
+	}
+	return msgpack.Unmarshal(c.message[c.body:end], body)
+}
+
+func (c *codec) Close() error {
+	return c.conn.Close()
+}
+
+// Sequence numbers may arrive as any integer type. Returns a value no
+// sequence number can have for anything else
+func toUint64(v interface{}) uint64 {
+	switch v := v.(type) {
+	case int64:
+		if v >= 0 {
+			return uint64(v)
+		}
+	case uint64:
+		return v
+	}
+	return ^uint64(0)
+}
+
+type clientCodec struct {
+	codec
+}
+
+// Returns a net/rpc ClientCodec that speaks msgpack over @conn
+func NewClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{newCodec(conn)}
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	return c.write(requestType, r.Seq, r.ServiceMethod, []interface{}{body})
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	offset, err := c.read(responseType, 4)
+	if err != nil {
+		return err
+	}
+	var seq, errval interface{}
+	if offset, seq, err = c.decode(offset); err != nil {
+		return err
+	}
+	if offset, errval, err = c.decode(offset); err != nil {
+		return err
+	}
+	r.Seq = toUint64(seq)
+	r.Error = ""
+	if errval != nil {
+		if s, ok := errval.(string); ok {
+			r.Error = s
+		} else {
+			r.Error = fmt.Sprint(errval)
+		}
+	}
+	c.body = offset
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+type serverCodec struct {
+	codec
+}
+
+// Returns a net/rpc ServerCodec that speaks msgpack over @conn
+func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{newCodec(conn)}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	offset, err := c.read(requestType, 4)
+	if err != nil {
+		return err
+	}
+	var seq, method interface{}
+	if offset, seq, err = c.decode(offset); err != nil {
+		return err
+	}
+	if offset, method, err = c.decode(offset); err != nil {
+		return err
+	}
+	name, ok := method.(string)
+	if !ok {
+		return errMalformed
+	}
+	h, err := msgpack.ReadHeader(c.message, offset)
+	if err != nil {
+		return err
+	}
+	if h.Type != msgpack.ArrayType {
+		return errMalformed
+	}
+	r.Seq = toUint64(seq)
+	r.ServiceMethod = name
+	c.body = -1
+	if h.Length > 0 {
+		c.body = offset + h.Size
+	}
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if r.Error != "" {
+		return c.write(responseType, r.Seq, r.Error, nil)
+	}
+	return c.write(responseType, r.Seq, nil, body)
+}
+
+// Returns a net/rpc Client that speaks msgpack over @conn
+func NewClient(conn io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn))
+}
+
+// Connects to a net/rpc server speaking msgpack
+func Dial(network, address string) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// Serves the default net/rpc server over @conn using msgpack, until the
+// connection is closed
+func ServeConn(conn io.ReadWriteCloser) {
+	rpc.ServeCodec(NewServerCodec(conn))
+}
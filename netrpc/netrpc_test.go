@@ -0,0 +1,140 @@
+package netrpc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+
+	"github.com/gtfierro/msgpack"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo, Rem int
+	Note     string `msgpack:"note"`
+}
+
+type Arith int
+
+func (t *Arith) Multiply(args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Divide(args *Args, quo *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	quo.Quo = args.A / args.B
+	quo.Rem = args.A % args.B
+	quo.Note = "ok"
+	return nil
+}
+
+func newClient(t *testing.T) *rpc.Client {
+	server := rpc.NewServer()
+	server.Register(new(Arith))
+	clientconn, serverconn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(serverconn))
+	client := NewClient(clientconn)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCall(t *testing.T) {
+	client := newClient(t)
+	var product int
+	if err := client.Call("Arith.Multiply", &Args{7, 8}, &product); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if product != 56 {
+		t.Errorf("Multiply should be 56 but was %v", product)
+	}
+	var quo Quotient
+	if err := client.Call("Arith.Divide", &Args{17, 5}, &quo); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if quo.Quo != 3 || quo.Rem != 2 || quo.Note != "ok" {
+		t.Errorf("Divide should be {3 2 ok} but was %+v", quo)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	client := newClient(t)
+	var quo Quotient
+	err := client.Call("Arith.Divide", &Args{1, 0}, &quo)
+	if err == nil || err.Error() != "divide by zero" {
+		t.Errorf("Call should fail with divide by zero but returned %v", err)
+	}
+	err = client.Call("Arith.Missing", &Args{1, 0}, &quo)
+	if err == nil {
+		t.Errorf("Call of a missing method should fail")
+	}
+	// the connection is still usable after errors
+	var product int
+	if err = client.Call("Arith.Multiply", &Args{2, 3}, &product); err != nil || product != 6 {
+		t.Errorf("Multiply should be 6 but was %v (%v)", product, err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	client := newClient(t)
+	calls := make([]*rpc.Call, 20)
+	for i := range calls {
+		calls[i] = client.Go("Arith.Multiply", &Args{i, i}, new(int), nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil || *call.Reply.(*int) != i*i {
+			t.Errorf("Multiply should be %v but was %v (%v)", i*i, *call.Reply.(*int), call.Error)
+		}
+	}
+}
+
+// A non-Go peer sends a plain msgpack-rpc request
+func TestWireFormat(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(new(Arith))
+	clientconn, serverconn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(serverconn))
+	defer clientconn.Close()
+
+	request, _ := msgpack.Marshal([]interface{}{0, 9, "Arith.Multiply", []interface{}{map[string]interface{}{"A": 6, "B": 7}}})
+	go clientconn.Write(request)
+	response, err := msgpack.ReadObject(clientconn, nil, 0)
+	if err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+	expected := []byte{0x94, 0x1, 0xcc, 0x9, 0xc0, 0x2a} // [1, 9, nil, 42]
+	if !bytes.Equal(response, expected) {
+		t.Errorf("Response should be %x but was %x", expected, response)
+	}
+}
+
+func TestMalformedHeaders(t *testing.T) {
+	for _, message := range [][]byte{
+		{0x94, 0x0, 0xd4, 0x5, 0x1, 0xa1, 0x6d, 0x90}, // seq an ext
+		{0x94, 0x0, 0x1, 0x81, 0x1, 0x2, 0x90},        // method {1: 2}
+	} {
+		clientconn, serverconn := net.Pipe()
+		go clientconn.Write(message)
+		var r rpc.Request
+		if err := NewServerCodec(serverconn).ReadRequestHeader(&r); err != errMalformed {
+			t.Errorf("ReadRequestHeader(%x) returned %v, want errMalformed", message, err)
+		}
+		clientconn.Close()
+	}
+
+	clientconn, serverconn := net.Pipe()
+	defer clientconn.Close()
+	go serverconn.Write([]byte{0x94, 0x1, 0x1, 0x81, 0x1, 0x2, 0xc0}) // error {1: 2}
+	var r rpc.Response
+	if err := NewClientCodec(clientconn).ReadResponseHeader(&r); err != errMalformed {
+		t.Errorf("ReadResponseHeader returned %v, want errMalformed", err)
+	}
+}
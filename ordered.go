@@ -0,0 +1,131 @@
+package msgpack
+
+// One entry of an OrderedMap
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// An OrderedMap is a msgpack map that keeps its keys in order. A Decoder
+// with OrderedMaps set decodes maps into these, in the order their keys
+// appear in the input, and encoding one writes the keys in slice order. A
+// key should appear only once; Set keeps it that way.
+type OrderedMap []KeyValue
+
+// Returns the value for @key and whether it was there
+func (m OrderedMap) Get(key string) (interface{}, bool) {
+	for i := range m {
+		if m[i].Key == key {
+			return m[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// Sets the value for @key, replacing the old value in place if there is one
+// and adding it to the end otherwise
+func (m *OrderedMap) Set(key string, value interface{}) {
+	for i := range *m {
+		if (*m)[i].Key == key {
+			(*m)[i].Value = value
+			return
+		}
+	}
+	*m = append(*m, KeyValue{Key: key, Value: value})
+}
+
+// Removes @key, keeping the other keys in order
+func (m *OrderedMap) Delete(key string) {
+	for i := range *m {
+		if (*m)[i].Key == key {
+			*m = append((*m)[:i], (*m)[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns the keys in order
+func (m OrderedMap) Keys() []string {
+	keys := make([]string, len(m))
+	for i := range m {
+		keys[i] = m[i].Key
+	}
+	return keys
+}
+
+// maps longer than this get a temporary index while being decoded, so that
+// finding duplicate keys doesn't take quadratic time
+const orderedIndexLength = 16
+
+func (dec *Decoder) parseOrderedMap(input *[]byte, offset int, depth int, check *cancelCheck) (OrderedMap, int) {
+	h, _ := readHeader(*input, offset)
+	initialoffset := offset
+	offset += h.Size
+	value := make(OrderedMap, 0, h.Length)
+	var index map[string]int
+	if h.Length > orderedIndexLength {
+		index = make(map[string]int, h.Length)
+	}
+	for mapidx := 0; mapidx < h.Length; mapidx++ {
+		check.element()
+		key, consumed := dec.parseMapKey(input, offset)
+		existing := -1
+		if index != nil {
+			if i, found := index[key]; found {
+				existing = i
+			}
+		} else {
+			for i := range value {
+				if value[i].Key == key {
+					existing = i
+					break
+				}
+			}
+		}
+		if existing >= 0 && dec.DuplicateKeys == ErrorOnDuplicate {
+			panic(&DuplicateKeyError{Key: key, Offset: offset})
+		}
+		newoffset, _value := dec.decode(input, offset+consumed, depth, check)
+		offset = newoffset
+
+		switch {
+		case existing < 0:
+			if index != nil {
+				index[key] = len(value)
+			}
+			value = append(value, KeyValue{Key: key, Value: _value})
+		case dec.DuplicateKeys == FirstWins:
+			Release(_value)
+		default:
+			Release(value[existing].Value)
+			value[existing].Value = _value
+		}
+	}
+	return value, offset - initialoffset
+}
+
+// Returns an upper bound on the bytes @m takes to encode
+func (enc *Encoder) maxOrderedMapSize(m OrderedMap) (int, error) {
+	size := maxContainerHeader
+	for _, kv := range m {
+		ksize, err := enc.maxEncodedSize(kv.Key)
+		if err != nil {
+			return 0, err
+		}
+		vsize, err := enc.maxEncodedSize(kv.Value)
+		if err != nil {
+			return 0, err
+		}
+		size += ksize + vsize
+	}
+	return size, nil
+}
+
+func (enc *Encoder) encodeOrderedMap(ret *[]byte, offset int, val OrderedMap) int {
+	offset = encodeMapHeader(*ret, offset, len(val))
+	for _, kv := range val {
+		offset = enc.encodeString(*ret, offset, kv.Key)
+		offset = enc.doEncode(kv.Value, ret, offset)
+	}
+	return offset
+}
@@ -0,0 +1,100 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOrderedMapDecode(t *testing.T) {
+	//{'z':1,'a':{'y':2,'b':3},'m':[{'k':4}]}
+	input := []byte{0x83, 0xa1, 0x7a, 0x1, 0xa1, 0x61, 0x82, 0xa1, 0x79, 0x2, 0xa1, 0x62, 0x3,
+		0xa1, 0x6d, 0x91, 0x81, 0xa1, 0x6b, 0x4}
+	dec := &Decoder{OrderedMaps: true}
+	offset, value := dec.Decode(&input, 0)
+	expected := OrderedMap{
+		{"z", int64(1)},
+		{"a", OrderedMap{{"y", int64(2)}, {"b", int64(3)}}},
+		{"m", []interface{}{OrderedMap{{"k", int64(4)}}}},
+	}
+	if offset != len(input) || !reflect.DeepEqual(value, expected) {
+		t.Errorf("Decoded %v ending at %d, want %v", value, offset, expected)
+	}
+
+	encoded, err := Marshal(value)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !bytes.Equal(encoded, input) {
+		t.Errorf("OrderedMap encoded as % x, want % x", encoded, input)
+	}
+}
+
+func TestOrderedMapDuplicateKeys(t *testing.T) {
+	//{'a':1,'b':2,'a':3}
+	input := []byte{0x83, 0xa1, 0x61, 0x1, 0xa1, 0x62, 0x2, 0xa1, 0x61, 0x3}
+	for policy, want := range map[DuplicateKeyPolicy]int64{LastWins: 3, FirstWins: 1} {
+		dec := &Decoder{OrderedMaps: true, DuplicateKeys: policy}
+		_, value := dec.Decode(&input, 0)
+		expected := OrderedMap{{"a", want}, {"b", int64(2)}}
+		if !reflect.DeepEqual(value, expected) {
+			t.Errorf("policy %v decoded %v, want %v", policy, value, expected)
+		}
+	}
+	var m OrderedMap
+	if err := (&Decoder{DuplicateKeys: ErrorOnDuplicate}).Unmarshal(input, &m); err == nil {
+		t.Errorf("Unmarshal into an OrderedMap allowed a duplicate key")
+	}
+}
+
+func TestOrderedMapLarge(t *testing.T) {
+	var m OrderedMap
+	for i := 0; i < 40; i++ {
+		m = append(m, KeyValue{string(rune('A' + i)), int64(i)})
+	}
+	// repeat a key past the point where an index is used
+	input, _ := Append(nil, append(m, KeyValue{"B", "again"}))
+	_, value := (&Decoder{OrderedMaps: true}).Decode(&input, 0)
+	got := value.(OrderedMap)
+	if len(got) != 40 || got[1].Value != "again" || !reflect.DeepEqual(got.Keys(), m.Keys()) {
+		t.Errorf("Decoded %v", got)
+	}
+	got = nil
+	if err := Unmarshal(input, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(got) != 40 || got[1].Value != "again" || !reflect.DeepEqual(got.Keys(), m.Keys()) {
+		t.Errorf("Unmarshaled %v", got)
+	}
+}
+
+func TestOrderedMapUnmarshal(t *testing.T) {
+	type withOrdered struct {
+		Fields OrderedMap
+	}
+	input, _ := Marshal(map[string]interface{}{
+		"Fields": OrderedMap{{"c", "x"}, {"b", map[string]interface{}{"n": 1}}, {"a", nil}},
+	})
+	var target withOrdered
+	if err := Unmarshal(input, &target); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	expected := OrderedMap{{"c", "x"}, {"b", map[string]interface{}{"n": int64(1)}}, {"a", nil}}
+	if !reflect.DeepEqual(target.Fields, expected) {
+		t.Errorf("Unmarshaled %v, want %v", target.Fields, expected)
+	}
+}
+
+func TestOrderedMapMethods(t *testing.T) {
+	var m OrderedMap
+	m.Set("b", 1)
+	m.Set("a", 2)
+	m.Set("b", 3)
+	if v, found := m.Get("b"); !found || v != 3 || !reflect.DeepEqual(m.Keys(), []string{"b", "a"}) {
+		t.Errorf("Set should replace in place: %v", m)
+	}
+	m.Delete("b")
+	if _, found := m.Get("b"); found || len(m) != 1 {
+		t.Errorf("Delete left %v", m)
+	}
+}
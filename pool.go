@@ -0,0 +1,75 @@
+package msgpack
+
+import (
+	"sync"
+)
+
+const DEFAULT_ARR_SIZE = 15
+const DEFAULT_MAP_SIZE = 15
+
+var arrpool = sync.Pool{
+	New: func() interface{} {
+		return make([]interface{}, DEFAULT_ARR_SIZE)
+	},
+}
+
+var mappool = sync.Pool{
+	New: func() interface{} {
+		return make(map[string]interface{}, DEFAULT_MAP_SIZE)
+	},
+}
+
+// Returns a slice of the given length. Small slices come out of
+// arrpool and can be handed back with Release
+func getNewArray(length int) []interface{} {
+	if length <= DEFAULT_ARR_SIZE {
+		return arrpool.Get().([]interface{})[:length]
+	} else {
+		return make([]interface{}, length)
+	}
+}
+
+// Returns an empty map with room for the given number of keys. Small
+// maps come out of mappool and can be handed back with Release
+func getNewMap(length int) map[string]interface{} {
+	if length <= DEFAULT_MAP_SIZE {
+		return mappool.Get().(map[string]interface{})
+	} else {
+		return make(map[string]interface{}, length)
+	}
+}
+
+// Hands the containers of a decoded value back to the decoder so that
+// later calls to Decode can reuse them instead of allocating. Any
+// []interface{} and map[string]interface{} found in @v (including nested
+// ones) are cleared and pooled. Neither @v nor anything taken out of it
+// may be used after calling Release.
+func Release(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			Release(v[i])
+		}
+		if cap(v) != DEFAULT_ARR_SIZE {
+			return
+		}
+		v = v[:cap(v)]
+		for i := range v {
+			v[i] = nil
+		}
+		arrpool.Put(v)
+	case map[string]interface{}:
+		length := len(v)
+		for k, val := range v {
+			Release(val)
+			delete(v, k)
+		}
+		if length <= DEFAULT_MAP_SIZE { // don't hang on to large tables
+			mappool.Put(v)
+		}
+	case OrderedMap:
+		for i := range v {
+			Release(v[i].Value)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package msgpack
+
+import "reflect"
+
+// Raw holds one msgpack object exactly as it was encoded. Unmarshal copies
+// an object's bytes into a Raw instead of decoding it, and Marshal writes a
+// Raw's bytes out as they are, so it must hold exactly one well-formed
+// object. A nil or empty Raw is written as nil.
+type Raw []byte
+
+var rawType = reflect.TypeOf(Raw(nil))
+
+// the type of a struct field tagged `msgpack:",remain"`
+var remainType = reflect.TypeOf(map[string]Raw(nil))
+
+func encodeRaw(buf []byte, offset int, val Raw) int {
+	if len(val) == 0 {
+		return encodeNil(buf, offset)
+	}
+	return offset + copy(buf[offset:], val)
+}
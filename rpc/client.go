@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrShutdown = errors.New("rpc: client is shut down")
+
+type response struct {
+	result interface{}
+	err    error
+}
+
+// A Client makes calls over a single connection. Calls may be made from
+// any number of goroutines at once; they are pipelined on the connection
+// and matched to their responses by message id.
+type Client struct {
+	// if positive, calls that take longer than this fail with
+	// context.DeadlineExceeded
+	Timeout time.Duration
+
+	conn   io.ReadWriteCloser
+	writer *messageWriter
+
+	mu      sync.Mutex
+	nextid  uint32
+	pending map[uint32]chan response
+	err     error // set once the connection has failed or been closed
+}
+
+// Returns a Client that makes calls over @conn, with no timeout. Responses
+// bigger than DEFAULT_MAX_MESSAGE_SIZE shut the client down.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	c := &Client{
+		conn:    conn,
+		writer:  &messageWriter{w: conn},
+		pending: make(map[uint32]chan response),
+	}
+	go c.readResponses(newMessageReader(conn, DEFAULT_MAX_MESSAGE_SIZE))
+	return c
+}
+
+// Connects to a server and returns a Client for it
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// Calls @method and waits for the result. A *ServerError is returned if the
+// server sent back an error
+func (c *Client) Call(method string, params ...interface{}) (interface{}, error) {
+	return c.CallContext(context.Background(), method, params...)
+}
+
+// Like Call, but gives up when @ctx is done and returns ctx.Err(). A
+// response that arrives after that is thrown away.
+func (c *Client) CallContext(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	done := make(chan response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	msgid := c.nextid
+	c.nextid++
+	c.pending[msgid] = done
+	c.mu.Unlock()
+
+	if err := c.writer.write(RequestType, msgid, method, params); err != nil {
+		c.forget(msgid)
+		return nil, err
+	}
+	select {
+	case resp := <-done:
+		return resp.result, resp.err
+	case <-ctx.Done():
+		c.forget(msgid)
+		return nil, ctx.Err()
+	}
+}
+
+// Sends a notification, which gets no response
+func (c *Client) Notify(method string, params ...interface{}) error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		params = []interface{}{}
+	}
+	return c.writer.write(NotificationType, method, params)
+}
+
+// Closes the connection. Outstanding calls fail with ErrShutdown
+func (c *Client) Close() error {
+	c.shutdown(ErrShutdown)
+	return c.conn.Close()
+}
+
+func (c *Client) forget(msgid uint32) {
+	c.mu.Lock()
+	delete(c.pending, msgid)
+	c.mu.Unlock()
+}
+
+// Fails all outstanding and future calls with @err
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	for msgid, done := range c.pending {
+		done <- response{err: err}
+		delete(c.pending, msgid)
+	}
+}
+
+func (c *Client) readResponses(reader *messageReader) {
+	for {
+		message, msgtype, err := reader.read()
+		if err == nil && (msgtype != ResponseType || len(message) != 4) {
+			err = ErrMalformed
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = ErrShutdown
+			}
+			c.shutdown(err)
+			c.conn.Close()
+			return
+		}
+		msgid, ok := toUint32(message[1])
+		if !ok {
+			continue
+		}
+		c.mu.Lock()
+		done, found := c.pending[msgid]
+		delete(c.pending, msgid)
+		c.mu.Unlock()
+		if !found {
+			continue // the call gave up already
+		}
+		if message[2] != nil {
+			done <- response{err: &ServerError{Value: message[2]}}
+		} else {
+			done <- response{result: message[3]}
+		}
+	}
+}
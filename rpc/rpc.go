@@ -0,0 +1,114 @@
+// Package rpc implements MessagePack-RPC clients and servers.
+//
+// Messages are msgpack arrays written back to back on a connection:
+//
+//	request       [0, msgid, method, params]
+//	response      [1, msgid, error, result]
+//	notification  [2, method, params]
+//
+// Requests on one connection are pipelined: a client may have any number
+// outstanding, and a server answers each as soon as its handler returns,
+// which need not be in the order the requests arrived.
+package rpc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gtfierro/msgpack"
+)
+
+const (
+	RequestType      = 0
+	ResponseType     = 1
+	NotificationType = 2
+)
+
+// messages bigger than this are refused and the connection closed
+const DEFAULT_MAX_MESSAGE_SIZE = 16 * 1024 * 1024
+
+var ErrMalformed = errors.New("rpc: malformed message")
+
+// A ServerError is the error half of a response, as sent by the server
+type ServerError struct {
+	Value interface{}
+}
+
+func (e *ServerError) Error() string {
+	if s, ok := e.Value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("rpc: server error %v", e.Value)
+}
+
+// Reads and decodes messages from one side of a connection
+type messageReader struct {
+	r       *bufio.Reader
+	buf     []byte
+	maxsize int
+}
+
+func newMessageReader(r io.Reader, maxsize int) *messageReader {
+	return &messageReader{r: bufio.NewReader(r), maxsize: maxsize}
+}
+
+// Returns the next message, which is always a non-empty array whose first
+// element is the message type
+func (m *messageReader) read() ([]interface{}, int, error) {
+	var err error
+	if m.buf, err = msgpack.ReadObject(m.r, m.buf[:0], m.maxsize); err != nil {
+		return nil, 0, err
+	}
+	// ReadObject only checks that the message is well-formed; Decode still
+	// refuses things it can't turn into values, like ext or non-str map
+	// keys, by returning offset 0
+	end, value := msgpack.Decode(&m.buf, 0)
+	if end != len(m.buf) {
+		return nil, 0, ErrMalformed
+	}
+	message, ok := value.([]interface{})
+	if !ok || len(message) == 0 {
+		return nil, 0, ErrMalformed
+	}
+	msgtype, ok := toUint32(message[0])
+	if !ok {
+		return nil, 0, ErrMalformed
+	}
+	return message, int(msgtype), nil
+}
+
+// Encodes and writes messages to one side of a connection. Safe for
+// concurrent use; each message goes out in a single Write
+type messageWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (m *messageWriter) write(message ...interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	buf, err := msgpack.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = m.w.Write(buf)
+	return err
+}
+
+// Message ids and types may arrive as any integer type
+func toUint32(v interface{}) (uint32, bool) {
+	switch v := v.(type) {
+	case int64:
+		if v >= 0 && v <= 0xffffffff {
+			return uint32(v), true
+		}
+	case uint64:
+		if v <= 0xffffffff {
+			return uint32(v), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,200 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func toInt(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	}
+	return -1
+}
+
+func newPipe(t *testing.T, s *Server) *Client {
+	clientconn, serverconn := net.Pipe()
+	go s.ServeConn(serverconn)
+	c := NewClient(clientconn)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testServer() *Server {
+	s := NewServer()
+	s.Register("add", func(params []interface{}) (interface{}, error) {
+		return toInt(params[0]) + toInt(params[1]), nil
+	})
+	s.Register("fail", func(params []interface{}) (interface{}, error) {
+		return nil, errors.New("it broke")
+	})
+	s.Register("panic", func(params []interface{}) (interface{}, error) {
+		panic("oops")
+	})
+	s.Register("channel", func(params []interface{}) (interface{}, error) {
+		return make(chan int), nil
+	})
+	s.Register("sleep", func(params []interface{}) (interface{}, error) {
+		time.Sleep(time.Duration(toInt(params[0])) * time.Millisecond)
+		return params[0], nil
+	})
+	return s
+}
+
+func TestCall(t *testing.T) {
+	c := newPipe(t, testServer())
+	result, err := c.Call("add", 1, 2)
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if toInt(result) != 3 {
+		t.Errorf("Result should be 3 but was %v", result)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	c := newPipe(t, testServer())
+	_, err := c.Call("fail")
+	if serr, ok := err.(*ServerError); !ok || serr.Error() != "it broke" {
+		t.Errorf("Call should fail with a ServerError 'it broke' but returned %v", err)
+	}
+	_, err = c.Call("missing")
+	if _, ok := err.(*ServerError); !ok {
+		t.Errorf("Call of a missing method should fail with a ServerError but returned %v", err)
+	}
+	_, err = c.Call("panic")
+	if _, ok := err.(*ServerError); !ok {
+		t.Errorf("Call of a method that panics should fail with a ServerError but returned %v", err)
+	}
+	_, err = c.Call("channel")
+	if _, ok := err.(*ServerError); !ok {
+		t.Errorf("Call returning something that can't be encoded should fail with a ServerError but returned %v", err)
+	}
+	if _, err = c.Call("add", make(chan int), 1); err == nil {
+		t.Errorf("Call with params that can't be encoded should fail")
+	}
+	if result, err := c.Call("add", 1, 1); err != nil || toInt(result) != 2 {
+		t.Errorf("Call after a failed one returned %v (%v), want 2", result, err)
+	}
+}
+
+func TestPipelinedCalls(t *testing.T) {
+	c := newPipe(t, testServer())
+	var wg sync.WaitGroup
+	finished := make(chan int64, 3)
+	for _, ms := range []int{60, 30, 1} {
+		wg.Add(1)
+		go func(ms int) {
+			defer wg.Done()
+			result, err := c.Call("sleep", ms)
+			if err != nil {
+				t.Errorf("Call failed: %v", err)
+				return
+			}
+			if toInt(result) != int64(ms) {
+				t.Errorf("Result should be %v but was %v", ms, result)
+			}
+			finished <- toInt(result)
+		}(ms)
+	}
+	wg.Wait()
+	close(finished)
+	// the quick call should not have waited on the slow ones
+	if first := <-finished; first != 1 {
+		t.Errorf("The 1ms call should finish first but %vms did", first)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	s := testServer()
+	notified := make(chan string, 1)
+	s.Register("event", func(params []interface{}) (interface{}, error) {
+		notified <- params[0].(string)
+		return nil, nil
+	})
+	c := newPipe(t, s)
+	if err := c.Notify("event", "hello"); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	select {
+	case got := <-notified:
+		if got != "hello" {
+			t.Errorf("Notification should be hello but was %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Notification never arrived")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	c := newPipe(t, testServer())
+	c.Timeout = 10 * time.Millisecond
+	if _, err := c.Call("sleep", 200); err != context.DeadlineExceeded {
+		t.Errorf("Call should time out but returned %v", err)
+	}
+	// the client keeps working after a timeout
+	c.Timeout = 0
+	if result, err := c.Call("add", 2, 2); err != nil || toInt(result) != 4 {
+		t.Errorf("Call should return 4 but returned %v (%v)", result, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	c := newPipe(t, testServer())
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := c.CallContext(ctx, "sleep", 200); err != context.Canceled {
+		t.Errorf("Call should be canceled but returned %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c := newPipe(t, testServer())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Call("sleep", 200)
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	if err := <-errs; err != ErrShutdown {
+		t.Errorf("Outstanding call should fail with ErrShutdown but returned %v", err)
+	}
+	if _, err := c.Call("add", 1, 1); err != ErrShutdown {
+		t.Errorf("Call after Close should fail with ErrShutdown but returned %v", err)
+	}
+}
+
+func TestMalformedRequest(t *testing.T) {
+	for _, message := range [][]byte{
+		{0x93, 0x5, 0x1, 0x2}, // [5, 1, 2]
+		{0x94, 0x0, 0x1, 0xa3, 0x61, 0x64, 0x64, 0x81, 0x1, 0x2}, // params {1: 2}
+		{0x94, 0x0, 0x1, 0xa3, 0x61, 0x64, 0x64, 0xd4, 0x5, 0x1}, // params an ext
+	} {
+		clientconn, serverconn := net.Pipe()
+		done := make(chan error)
+		go func() {
+			done <- testServer().ServeConn(serverconn)
+		}()
+		go clientconn.Write(message)
+		select {
+		case err := <-done:
+			if err != ErrMalformed {
+				t.Errorf("ServeConn returned %v for malformed message %x, want ErrMalformed", err, message)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Server should close the connection on malformed message %x", message)
+		}
+		clientconn.Close()
+	}
+}
@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// A Handler carries out one method. It gets the params of the request or
+// notification, and its result or error are sent back in the response
+// (for notifications they are thrown away). Handlers run in their own
+// goroutines and may be called concurrently.
+type Handler func(params []interface{}) (interface{}, error)
+
+// A Server dispatches requests and notifications to registered Handlers
+type Server struct {
+	// messages bigger than this close the connection
+	MaxMessageSize int
+	// if not nil, told why each connection Serve accepted was closed,
+	// unless it was closed cleanly
+	ErrorLog func(error)
+
+	mu      sync.RWMutex
+	methods map[string]Handler
+}
+
+// Returns a Server with no methods and DEFAULT_MAX_MESSAGE_SIZE
+func NewServer() *Server {
+	return &Server{
+		MaxMessageSize: DEFAULT_MAX_MESSAGE_SIZE,
+		methods:        make(map[string]Handler),
+	}
+}
+
+// Makes @handler answer calls to @method, replacing any handler already
+// registered for it
+func (s *Server) Register(method string, handler Handler) {
+	s.mu.Lock()
+	s.methods[method] = handler
+	s.mu.Unlock()
+}
+
+// Accepts connections on @l and serves each in its own goroutine. Returns
+// when Accept fails
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(conn); err != nil && s.ErrorLog != nil {
+				s.ErrorLog(err)
+			}
+		}()
+	}
+}
+
+// Serves requests on @conn until it is closed or sends something that isn't
+// a valid message, then closes it. Waits for outstanding handlers to
+// finish before returning. Returns nil if the other side closed the
+// connection between messages, and otherwise why it was closed.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) error {
+	var handlers sync.WaitGroup
+	defer func() {
+		handlers.Wait()
+		conn.Close()
+	}()
+	reader := newMessageReader(conn, s.MaxMessageSize)
+	writer := &messageWriter{w: conn}
+	for {
+		message, msgtype, err := reader.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case msgtype == RequestType && len(message) == 4:
+			msgid, ok := toUint32(message[1])
+			method, ok2 := message[2].(string)
+			params, ok3 := message[3].([]interface{})
+			if !ok || !ok2 || !ok3 {
+				return ErrMalformed
+			}
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				result, err := s.call(method, params)
+				var errval interface{}
+				if err != nil {
+					errval = err.Error()
+					result = nil
+				}
+				if err = writer.write(ResponseType, msgid, errval, result); err != nil && result != nil {
+					// the result couldn't be encoded; the caller still
+					// needs an answer
+					writer.write(ResponseType, msgid, err.Error(), nil)
+				}
+			}()
+		case msgtype == NotificationType && len(message) == 3:
+			method, ok := message[1].(string)
+			params, ok2 := message[2].([]interface{})
+			if !ok || !ok2 {
+				return ErrMalformed
+			}
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				s.call(method, params)
+			}()
+		default:
+			return ErrMalformed
+		}
+	}
+}
+
+// Runs the handler for @method. A handler that panics returns the panic as
+// its error, so one bad call doesn't take down the server
+func (s *Server) call(method string, params []interface{}) (result interface{}, err error) {
+	s.mu.RLock()
+	handler, found := s.methods[method]
+	s.mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("rpc: no such method %q", method)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("rpc: method %q panicked: %v", method, r)
+		}
+	}()
+	return handler(params)
+}
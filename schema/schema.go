@@ -0,0 +1,405 @@
+// Package schema checks that msgpack messages have the shape a program
+// expects: the types of values, which map keys must be there, ranges for
+// numbers, lengths for strings and arrays, and the allowed values of
+// enumerated strings. Validation works on the encoded bytes and builds no
+// Go values apart from numbers, so it is cheap enough to run on every
+// message before decoding it.
+//
+// A Schema can be declared in Go:
+//
+//	reading := &schema.Schema{
+//		Type:     "map",
+//		Required: []string{"id", "temp"},
+//		Properties: map[string]*schema.Schema{
+//			"id":   {Type: "str", MaxLength: schema.Len(64)},
+//			"temp": {Type: "number", Minimum: schema.Bound(-50), Maximum: schema.Bound(150)},
+//			"unit": {Type: "str", Enum: []string{"C", "F"}},
+//			"tags": {Type: "array", MaxLength: schema.Len(8), Items: &schema.Schema{Type: "str"}},
+//		},
+//		Closed: true,
+//	}
+//
+// or read from a JSON document with Parse, using the names in the json
+// tags on Schema's fields:
+//
+//	{"type": "map", "required": ["id", "temp"], "closed": true,
+//	 "properties": {"temp": {"type": "number", "minimum": -50, "maximum": 150}}}
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gtfierro/msgpack"
+)
+
+// The shape of one msgpack value. The zero Schema accepts anything, and
+// each field that is set narrows that down. Settings that don't apply to
+// the type of the value being checked are ignored, so a Schema with no Type
+// can still put a range on whichever values turn out to be numbers.
+type Schema struct {
+	// the type the value must have: one of the msgpack type names "nil",
+	// "bool", "int", "uint", "float", "str", "bin", "array", "map" or
+	// "ext", or "integer" for int or uint, "number" for int, uint or float,
+	// and "" or "any" for anything
+	Type string `json:"type,omitempty"`
+	// nil is accepted as well as Type
+	Nullable bool `json:"nullable,omitempty"`
+
+	// for numbers, the smallest and largest values allowed. Integers are
+	// compared as float64, so bounds past 2^53 are not exact
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+
+	// the shortest and longest a str or bin (in bytes), array (in
+	// elements) or map (in key/value pairs) may be
+	MinLength *int `json:"minLength,omitempty"`
+	MaxLength *int `json:"maxLength,omitempty"`
+
+	// a str must be one of these
+	Enum []string `json:"enum,omitempty"`
+
+	// the schema every array element must match
+	Items *Schema `json:"items,omitempty"`
+
+	// the schemas for the values of these str keys in a map
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	// keys a map must have
+	Required []string `json:"required,omitempty"`
+	// the schema for the values of keys not in Properties
+	Values *Schema `json:"values,omitempty"`
+	// keys not in Properties, including keys that aren't str, are not
+	// allowed
+	Closed bool `json:"closed,omitempty"`
+}
+
+// Returns a pointer to @n, for MinLength and MaxLength
+func Len(n int) *int {
+	return &n
+}
+
+// Returns a pointer to @f, for Minimum and Maximum
+func Bound(f float64) *float64 {
+	return &f
+}
+
+// A ValidationError reports a value that doesn't match its schema
+type ValidationError struct {
+	// where the value is in the message, e.g. "readings[3].temp". Empty
+	// for the message itself
+	Path string
+	// where the value starts
+	Offset int
+	Msg    string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("schema: %s at offset %d", e.Msg, e.Offset)
+	}
+	return fmt.Sprintf("schema: %s: %s at offset %d", e.Path, e.Msg, e.Offset)
+}
+
+// Reads a Schema from the JSON document @doc. Fields it doesn't know and
+// type names that don't exist are errors
+func Parse(doc []byte) (*Schema, error) {
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.DisallowUnknownFields()
+	var s Schema
+	if err := d.Decode(&s); err != nil {
+		return nil, fmt.Errorf("schema: %v", err)
+	}
+	if err := s.check(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Looks for mistakes in @s, which is at @path in its document
+func (s *Schema) check(path string) error {
+	if _, known := typeNames[s.Type]; !known {
+		return fmt.Errorf("schema: %s: unknown type %q", describe(path), s.Type)
+	}
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		return fmt.Errorf("schema: %s: minimum is more than maximum", describe(path))
+	}
+	if s.MinLength != nil && s.MaxLength != nil && *s.MinLength > *s.MaxLength {
+		return fmt.Errorf("schema: %s: minLength is more than maxLength", describe(path))
+	}
+	if s.Items != nil {
+		if err := s.Items.check(path + "[]"); err != nil {
+			return err
+		}
+	}
+	if s.Values != nil {
+		if err := s.Values.check(path + ".*"); err != nil {
+			return err
+		}
+	}
+	for key, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("schema: %s: property %q has no schema", describe(path), key)
+		}
+		if err := property.check(join(path, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func describe(path string) string {
+	if path == "" {
+		return "top level"
+	}
+	return path
+}
+
+// Type name -> the msgpack types it accepts. nil for anything
+var typeNames = map[string][]msgpack.Type{
+	"":        nil,
+	"any":     nil,
+	"nil":     {msgpack.NilType},
+	"bool":    {msgpack.BoolType},
+	"int":     {msgpack.IntType},
+	"uint":    {msgpack.UintType},
+	"float":   {msgpack.FloatType},
+	"str":     {msgpack.StrType},
+	"bin":     {msgpack.BinType},
+	"array":   {msgpack.ArrayType},
+	"map":     {msgpack.MapType},
+	"ext":     {msgpack.ExtType},
+	"integer": {msgpack.IntType, msgpack.UintType},
+	"number":  {msgpack.IntType, msgpack.UintType, msgpack.FloatType},
+}
+
+// Checks that @data holds exactly one msgpack object and that it matches
+// @s. Returns a *ValidationError for the first mismatch found, or a
+// *msgpack.DecodeError if @data is malformed.
+func (s *Schema) Validate(data []byte) error {
+	end, err := s.ValidateAt(data, 0)
+	if err != nil {
+		return err
+	}
+	if end != len(data) {
+		return &msgpack.DecodeError{Offset: end, Msg: "extra bytes after object"}
+	}
+	return nil
+}
+
+// Checks that the msgpack object starting at @offset in @data matches @s,
+// and returns the offset just past it.
+func (s *Schema) ValidateAt(data []byte, offset int) (int, error) {
+	v := validator{data: data}
+	end, err := v.value(s, offset)
+	if err != nil {
+		return offset, err
+	}
+	return end, nil
+}
+
+type validator struct {
+	data []byte
+}
+
+func (v *validator) fail(offset int, format string, args ...interface{}) error {
+	return &ValidationError{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Adds @segment to the front of the path of @err if it is a
+// ValidationError
+func within(err error, segment string) error {
+	if verr, ok := err.(*ValidationError); ok {
+		if verr.Path == "" || verr.Path[0] == '[' {
+			verr.Path = segment + verr.Path
+		} else {
+			verr.Path = segment + "." + verr.Path
+		}
+	}
+	return err
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Checks the object at @offset against @s and returns the offset past it
+func (v *validator) value(s *Schema, offset int) (int, error) {
+	if s == nil {
+		return msgpack.Skip(v.data, offset)
+	}
+	h, err := msgpack.ReadHeader(v.data, offset)
+	if err != nil {
+		return offset, err
+	}
+	types, known := typeNames[s.Type]
+	if !known {
+		return offset, v.fail(offset, "unknown type %q in schema", s.Type)
+	}
+	if h.Type == msgpack.NilType && s.Nullable {
+		return offset + h.Size, nil
+	}
+	if types != nil && !hasType(types, h.Type) {
+		return offset, v.fail(offset, "expected %s, got %v", s.Type, h.Type)
+	}
+
+	switch h.Type {
+	case msgpack.IntType, msgpack.UintType, msgpack.FloatType:
+		if s.Minimum != nil || s.Maximum != nil {
+			if err := v.number(s, offset); err != nil {
+				return offset, err
+			}
+		}
+	case msgpack.StrType, msgpack.BinType:
+		if err := v.length(s, offset, h.Length); err != nil {
+			return offset, err
+		}
+		if h.Type == msgpack.StrType && s.Enum != nil {
+			str := v.data[offset+h.Size : offset+h.Size+h.Length]
+			if !inEnum(s.Enum, str) {
+				return offset, v.fail(offset, "%q is not one of %q", str, s.Enum)
+			}
+		}
+	case msgpack.ArrayType:
+		if err := v.length(s, offset, h.Length); err != nil {
+			return offset, err
+		}
+		return v.array(s, offset, h)
+	case msgpack.MapType:
+		if err := v.length(s, offset, h.Length); err != nil {
+			return offset, err
+		}
+		return v.object(s, offset, h)
+	}
+	return msgpack.Skip(v.data, offset)
+}
+
+func hasType(types []msgpack.Type, t msgpack.Type) bool {
+	for _, allowed := range types {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+func inEnum(enum []string, str []byte) bool {
+	for _, allowed := range enum {
+		if allowed == string(str) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the number at @offset against the bounds in @s
+func (v *validator) number(s *Schema, offset int) error {
+	var value float64
+	var text string
+	switch n := decodeNumber(v.data, offset).(type) {
+	case int64:
+		value, text = float64(n), strconv.FormatInt(n, 10)
+	case uint64:
+		value, text = float64(n), strconv.FormatUint(n, 10)
+	case float64:
+		value, text = n, strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	if s.Minimum != nil && value < *s.Minimum {
+		return v.fail(offset, "%s is less than the minimum %v", text, *s.Minimum)
+	}
+	if s.Maximum != nil && value > *s.Maximum {
+		return v.fail(offset, "%s is more than the maximum %v", text, *s.Maximum)
+	}
+	return nil
+}
+
+// Decodes the number at @offset, whose header has already been read
+func decodeNumber(data []byte, offset int) interface{} {
+	_, value := msgpack.Decode(&data, offset)
+	return value
+}
+
+// Checks the length @n of the object at @offset against the limits in @s
+func (v *validator) length(s *Schema, offset int, n int) error {
+	if s.MinLength != nil && n < *s.MinLength {
+		return v.fail(offset, "length %d is less than the minimum %d", n, *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return v.fail(offset, "length %d is more than the maximum %d", n, *s.MaxLength)
+	}
+	return nil
+}
+
+// Checks the elements of the array at @offset, which has header @h
+func (v *validator) array(s *Schema, offset int, h msgpack.Header) (int, error) {
+	if s.Items == nil {
+		return msgpack.Skip(v.data, offset)
+	}
+	offset += h.Size
+	var err error
+	for i := 0; i < h.Length; i++ {
+		if offset, err = v.value(s.Items, offset); err != nil {
+			return offset, within(err, "["+strconv.Itoa(i)+"]")
+		}
+	}
+	return offset, nil
+}
+
+// Checks the keys and values of the map at @offset, which has header @h
+func (v *validator) object(s *Schema, offset int, h msgpack.Header) (int, error) {
+	if s.Properties == nil && s.Required == nil && s.Values == nil && !s.Closed {
+		return msgpack.Skip(v.data, offset)
+	}
+	start := offset
+	var found []bool // which Required keys have been seen
+	if len(s.Required) > 0 {
+		found = make([]bool, len(s.Required))
+	}
+	offset += h.Size
+	for i := 0; i < h.Length; i++ {
+		kh, err := msgpack.ReadHeader(v.data, offset)
+		if err != nil {
+			return offset, err
+		}
+		var key []byte
+		if kh.Type == msgpack.StrType {
+			key = v.data[offset+kh.Size : offset+kh.Size+kh.Length]
+		}
+		keyoffset := offset
+		if offset, err = msgpack.Skip(v.data, offset); err != nil {
+			return offset, err
+		}
+
+		property, known := s.Properties[string(key)]
+		if key == nil || !known {
+			if s.Closed {
+				if key == nil {
+					return keyoffset, v.fail(keyoffset, "unexpected %v key", kh.Type)
+				}
+				return keyoffset, v.fail(keyoffset, "unexpected key %q", key)
+			}
+			property = s.Values
+		}
+		for r, name := range s.Required {
+			if key != nil && name == string(key) {
+				found[r] = true
+			}
+		}
+		if offset, err = v.value(property, offset); err != nil {
+			if key == nil {
+				return offset, within(err, "["+kh.String()+"]")
+			}
+			return offset, within(err, string(key))
+		}
+	}
+	for r, name := range s.Required {
+		if !found[r] {
+			return start, v.fail(start, "missing required key %q", name)
+		}
+	}
+	return offset, nil
+}
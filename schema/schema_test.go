@@ -0,0 +1,102 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/gtfierro/msgpack"
+)
+
+var reading = &Schema{
+	Type:     "map",
+	Required: []string{"id", "temp"},
+	Properties: map[string]*Schema{
+		"id":   {Type: "str", MaxLength: Len(8)},
+		"temp": {Type: "number", Minimum: Bound(0), Maximum: Bound(150)},
+		"unit": {Type: "str", Enum: []string{"C", "F"}},
+		"tags": {Type: "array", MaxLength: Len(2), Items: &Schema{Type: "str"}},
+		"note": {Type: "str", Nullable: true},
+	},
+	Closed: true,
+}
+
+func encode(t *testing.T, v interface{}) []byte {
+	bytes, err := msgpack.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	return bytes
+}
+
+func TestValidate(t *testing.T) {
+	good := map[string]interface{}{"id": "a1", "temp": 21.5, "unit": "C", "tags": []interface{}{"x"}, "note": nil}
+	if err := reading.Validate(encode(t, good)); err != nil {
+		t.Errorf("Valid message was rejected: %v", err)
+	}
+	for _, test := range []struct {
+		message map[string]interface{}
+		path    string
+	}{
+		{map[string]interface{}{"id": "a1"}, ""},                             // missing temp
+		{map[string]interface{}{"id": 1, "temp": 1}, "id"},                   // wrong type
+		{map[string]interface{}{"id": "toolongforit", "temp": 1}, "id"},      // too long
+		{map[string]interface{}{"id": "a1", "temp": 200}, "temp"},            // too big
+		{map[string]interface{}{"id": "a1", "temp": 1, "unit": "K"}, "unit"}, // not in enum
+		{map[string]interface{}{"id": "a1", "temp": 1, "extra": true}, ""},   // closed
+		{map[string]interface{}{"id": "a1", "temp": 1, "tags": []interface{}{"x", 2}}, "tags[1]"},
+		{map[string]interface{}{"id": "a1", "temp": 1, "tags": []interface{}{"x", "y", "z"}}, "tags"},
+	} {
+		err, ok := reading.Validate(encode(t, test.message)).(*ValidationError)
+		if !ok {
+			t.Errorf("%v should have failed validation, got %v", test.message, err)
+			continue
+		}
+		if err.Path != test.path {
+			t.Errorf("%v failed at %q (%v), want %q", test.message, err.Path, err, test.path)
+		}
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	if _, ok := reading.Validate([]byte{0x82, 0xa2, 0x69}).(*msgpack.DecodeError); !ok {
+		t.Errorf("Truncated message should give a DecodeError")
+	}
+	bytes := encode(t, map[string]interface{}{"id": "a", "temp": 1})
+	if _, ok := reading.Validate(append(bytes, 0xc0)).(*msgpack.DecodeError); !ok {
+		t.Errorf("Trailing bytes should give a DecodeError")
+	}
+	end, err := reading.ValidateAt(append(bytes, 0xc0), 0)
+	if err != nil || end != len(bytes) {
+		t.Errorf("ValidateAt returned %d (%v), want %d", end, err, len(bytes))
+	}
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`{
+		"type": "map",
+		"required": ["readings"],
+		"properties": {
+			"readings": {"type": "array", "items": {"type": "map", "values": {"type": "integer", "maximum": 10}}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	message := map[string]interface{}{"readings": []interface{}{
+		map[string]interface{}{"a": 1},
+		map[string]interface{}{"b": 11},
+	}}
+	if err, ok := s.Validate(encode(t, message)).(*ValidationError); !ok || err.Path != "readings[1].b" {
+		t.Errorf("Validate returned %v, want a failure at readings[1].b", err)
+	}
+
+	for _, doc := range []string{
+		`{"type": "string"}`,
+		`{"type": "map", "propertys": {}}`,
+		`{"minLength": 3, "maxLength": 2}`,
+		`{"items": {"type": "list"}}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("Parse(%s) should have failed", doc)
+		}
+	}
+}
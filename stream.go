@@ -0,0 +1,82 @@
+package msgpack
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrTooLarge = errors.New("msgpack: object is larger than the size limit")
+
+// Reads exactly one msgpack object from @r and appends its bytes to @dst,
+// returning the extended slice. This is for streams where objects are sent
+// back to back with nothing to say where one ends, as in msgpack-rpc. Each
+// header is read before the bytes it describes, so @r should be buffered.
+// The object is checked to be well-formed as it is read. If it would be
+// longer than @limit bytes, ReadObject stops and returns ErrTooLarge
+// without reading the rest of it; a @limit of 0 or less means no limit.
+// Returns io.EOF if @r ends before the object starts and
+// io.ErrUnexpectedEOF if it ends partway through.
+func ReadObject(r io.Reader, dst []byte, limit int) ([]byte, error) {
+	start := len(dst)
+	// objects still to be read; containers add their elements to this
+	remaining := 1
+	for remaining > 0 {
+		offset := len(dst)
+		var err error
+		if dst, err = readFull(r, dst, 1); err != nil {
+			if err == io.EOF && offset > start {
+				err = io.ErrUnexpectedEOF
+			}
+			return dst, err
+		}
+		if dst, err = readFull(r, dst, headerSize(dst[offset])-1); err != nil {
+			return dst, unexpectedEOF(err)
+		}
+		h, err := readHeader(dst, offset)
+		if err != nil {
+			return dst, err
+		}
+		remaining--
+		switch h.Type {
+		case ArrayType:
+			remaining += h.Length
+		case MapType:
+			remaining += 2 * h.Length
+		default:
+			if limit > 0 && len(dst)-start+h.Length > limit {
+				return dst, ErrTooLarge
+			}
+			if dst, err = readFull(r, dst, h.Length); err != nil {
+				return dst, unexpectedEOF(err)
+			}
+		}
+		// every object left takes at least a byte
+		if limit > 0 && len(dst)-start+remaining > limit {
+			return dst, ErrTooLarge
+		}
+	}
+	return dst, nil
+}
+
+// Appends @n bytes read from @r to @dst
+func readFull(r io.Reader, dst []byte, n int) ([]byte, error) {
+	if n == 0 {
+		return dst, nil
+	}
+	offset := len(dst)
+	if cap(dst)-offset < n {
+		grown := make([]byte, offset, 2*cap(dst)+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = dst[:offset+n]
+	read, err := io.ReadFull(r, dst[offset:])
+	return dst[:offset+read], err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadObject(t *testing.T) {
+	//[{'a':[1,2]},'b'], then 'abc', then 256
+	stream := []byte{0x92, 0x81, 0xa1, 0x61, 0x92, 0x1, 0x2, 0xa1, 0x62, 0xa3, 0x61, 0x62, 0x63, 0xcd, 0x1, 0x0}
+	r := bufio.NewReader(bytes.NewReader(stream))
+	for _, expected := range [][]byte{stream[:9], stream[9:13], stream[13:]} {
+		object, err := ReadObject(r, nil, 0)
+		if err != nil {
+			t.Fatalf("ReadObject failed: %v", err)
+		}
+		if !bytes.Equal(object, expected) {
+			t.Errorf("ReadObject should be %x but was %x", expected, object)
+		}
+	}
+	if _, err := ReadObject(r, nil, 0); err != io.EOF {
+		t.Errorf("ReadObject should return io.EOF at the end but returned %v", err)
+	}
+}
+
+func TestReadObjectAppends(t *testing.T) {
+	r := bytes.NewReader([]byte{0xc3})
+	object, err := ReadObject(r, []byte{0xc0}, 0)
+	if err != nil || !bytes.Equal(object, []byte{0xc0, 0xc3}) {
+		t.Errorf("ReadObject should be c0c3 but was %x (%v)", object, err)
+	}
+}
+
+func TestReadObjectErrors(t *testing.T) {
+	for _, input := range [][]byte{
+		{0x92, 0x1},
+		{0xcd, 0x1},
+		{0xa3, 0x61},
+		{0x81, 0xa1, 0x61},
+	} {
+		if _, err := ReadObject(bytes.NewReader(input), []byte{0xc0}, 0); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadObject(%x) should fail with io.ErrUnexpectedEOF but returned %v", input, err)
+		}
+	}
+	if _, err := ReadObject(bytes.NewReader([]byte{0xc1}), nil, 0); err == nil {
+		t.Errorf("ReadObject should not accept 0xc1")
+	}
+	// str32 claiming 4GB
+	if _, err := ReadObject(bytes.NewReader([]byte{0xdb, 0xff, 0xff, 0xff, 0xff}), nil, 1024); err != ErrTooLarge {
+		t.Errorf("ReadObject should fail with ErrTooLarge but returned %v", err)
+	}
+	// array32 claiming 4 billion elements
+	if _, err := ReadObject(bytes.NewReader([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}), nil, 1024); err != ErrTooLarge {
+		t.Errorf("ReadObject should fail with ErrTooLarge but returned %v", err)
+	}
+}
@@ -0,0 +1,585 @@
+package msgpack
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// Decodes the msgpack object in @data into the value @v points to. @data
+// must hold exactly one object. Where Decode always builds
+// map[string]interface{} and []interface{} trees, Unmarshal fills in
+// whatever @v points to, including structs, typed slices and maps, and
+// pointers:
+//
+//   - struct fields are matched to map keys by their `msgpack:"name"` tag,
+//     or by field name if they have none (falling back to a case-insensitive
+//     match). Fields tagged `msgpack:"-"` and unexported fields are left
+//     alone, as are keys that match no field. The fields of a struct
+//     tagged `msgpack:",inline"` are matched as if they belonged to the
+//     outer struct, which allocates it if it is a nil pointer. A field of
+//     type map[string]Raw tagged `msgpack:",remain"` collects the keys that
+//     match no other field, so that Marshal can write them back out. A
+//     struct can also be decoded from an array, as Marshal writes asArray
+//     structs: elements fill the fields in order, and any extra elements
+//     are ignored
+//   - ints, uints and floats go into any numeric field they fit in
+//   - str and bin both go into strings and []byte
+//   - nil sets pointers, slices, maps and interfaces to nil and anything
+//     else to its zero value
+//   - interface{} gets what Decode would return
+//   - an OrderedMap gets a map's keys in the order they appear
+//   - a Raw gets a copy of the object's bytes, left undecoded
+//   - time.Time, and types whose pointer implements
+//     gopkg.in/vmihailenco/msgpack.v2's Unmarshaler or CustomDecoder, are
+//     decoded by it, as Marshal has it encode them
+//
+// The object is checked to be complete and well-formed before anything is
+// decoded. Values that don't fit their destination cause a *DecodeError.
+func Unmarshal(data []byte, v interface{}) error {
+	return defaultDecoder.Unmarshal(data, v)
+}
+
+// Like the package-level Unmarshal, but interns strings and handles
+// duplicate map keys as configured. With FirstWins, a struct field or Go map
+// entry keeps the first value given for it. With ErrorOnDuplicate, a
+// repeated key is a *DuplicateKeyError and nothing is decoded. With
+// DisallowUnknownFields, a key that matches no struct field is a
+// *DecodeError.
+func (dec *Decoder) Unmarshal(data []byte, v interface{}) error {
+	return dec.unmarshalTop("Unmarshal", data, v, &decodeState{Decoder: dec})
+}
+
+// Checks @data and decodes it into what @v points to for the function
+// @name, as @state says
+func (dec *Decoder) unmarshalTop(name string, data []byte, v interface{}, state *decodeState) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: %s needs a non-nil pointer, not %T", name, v)
+	}
+	end, err := dec.Skip(data, 0)
+	if err != nil {
+		return err
+	}
+	if end != len(data) {
+		return &DecodeError{Offset: end, Msg: "extra bytes after object"}
+	}
+	_, err = state.unmarshal(&data, 0, rv.Elem())
+	return err
+}
+
+// Decodes the msgpack object in @data into the value @v points to, like
+// Unmarshal, but updating what is already there instead of replacing it:
+//
+//   - struct fields and map entries with no key in the message are left
+//     alone, and ones with a key are updated the same way, so nested
+//     structs and maps are merged too
+//   - an existing map is added to rather than replaced
+//   - slices keep their backing array if it is big enough, but their
+//     elements are replaced, not merged
+//   - a pointer that is already set is decoded through, and nil sets it
+//     to nil
+//
+// This is useful for applying a partial update over defaults.
+func UnmarshalInto(data []byte, v interface{}) error {
+	return defaultDecoder.UnmarshalInto(data, v)
+}
+
+// Like the package-level UnmarshalInto, but with this Decoder's settings
+func (dec *Decoder) UnmarshalInto(data []byte, v interface{}) error {
+	return dec.unmarshalTop("UnmarshalInto", data, v, &decodeState{Decoder: dec, merge: true})
+}
+
+// The settings and state of one Unmarshal, UnmarshalInto or
+// UnmarshalContext call
+type decodeState struct {
+	*Decoder
+	// update the destination instead of replacing it
+	merge bool
+	// how many values the one being decoded is inside of
+	depth int
+	// for UnmarshalContext, counts elements and checks the context
+	check *cancelCheck
+}
+
+func typeError(offset int, h Header, t reflect.Type) error {
+	return &DecodeError{Offset: offset, Msg: fmt.Sprintf("cannot unmarshal %v into Go value of type %v", h.Type, t)}
+}
+
+// Decodes the object at @offset, which is known to be well-formed, into
+// @v. Returns the offset following the object
+func (dec *decodeState) unmarshal(input *[]byte, offset int, v reflect.Value) (int, error) {
+	if dec.depth >= dec.maxDepth() {
+		return offset, tooDeepError(offset)
+	}
+	dec.depth++
+	end, err := dec.unmarshalValue(input, offset, v)
+	dec.depth--
+	return end, err
+}
+
+func (dec *decodeState) unmarshalValue(input *[]byte, offset int, v reflect.Value) (int, error) {
+	h, _ := ReadHeader(*input, offset)
+	end := offset + h.Size + h.Length
+
+	if v.Type() == rawType {
+		end, err := Skip(*input, offset)
+		if err != nil {
+			return offset, err
+		}
+		var b []byte
+		if dec.merge {
+			b = v.Bytes()[:0]
+		}
+		v.SetBytes(append(b, (*input)[offset:end]...))
+		return end, nil
+	}
+
+	if h.Type == NilType {
+		v.Set(reflect.Zero(v.Type()))
+		return offset + 1, nil
+	}
+
+	if selfDecodingFor(v.Type()) {
+		return dec.unmarshalSelf(input, offset, v)
+	}
+
+	if v.Type() == orderedMapType {
+		if h.Type != MapType {
+			return offset, typeError(offset, h, v.Type())
+		}
+		return dec.unmarshalOrderedMap(input, offset+h.Size, h.Length, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return dec.unmarshal(input, offset, v.Elem())
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return offset, typeError(offset, h, v.Type())
+		}
+		if h.Type == BinType {
+			v.Set(reflect.ValueOf(append([]byte{}, (*input)[offset+h.Size:end]...)))
+			return end, nil
+		}
+		newoffset, value, err := dec.tryDecode(input, offset, dec.depth, dec.check)
+		if err != nil {
+			return offset, err
+		}
+		if value == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return newoffset, nil
+
+	case reflect.Bool:
+		if h.Type != BoolType {
+			return offset, typeError(offset, h, v.Type())
+		}
+		v.SetBool((*input)[offset] == 0xc3)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var value int64
+		switch h.Type {
+		case IntType:
+			value, _ = parseInt(input, offset)
+		case UintType:
+			u, _ := parseUint(input, offset)
+			if int64(u) < 0 {
+				return offset, typeError(offset, h, v.Type())
+			}
+			value = int64(u)
+		default:
+			return offset, typeError(offset, h, v.Type())
+		}
+		if v.OverflowInt(value) {
+			return offset, typeError(offset, h, v.Type())
+		}
+		v.SetInt(value)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var value uint64
+		switch h.Type {
+		case IntType:
+			i, _ := parseInt(input, offset)
+			if i < 0 {
+				return offset, typeError(offset, h, v.Type())
+			}
+			value = uint64(i)
+		case UintType:
+			value, _ = parseUint(input, offset)
+		default:
+			return offset, typeError(offset, h, v.Type())
+		}
+		if v.OverflowUint(value) {
+			return offset, typeError(offset, h, v.Type())
+		}
+		v.SetUint(value)
+
+	case reflect.Float32, reflect.Float64:
+		switch h.Type {
+		case FloatType:
+			value, _ := parseFloat(input, offset)
+			v.SetFloat(value)
+		case IntType:
+			value, _ := parseInt(input, offset)
+			v.SetFloat(float64(value))
+		case UintType:
+			value, _ := parseUint(input, offset)
+			v.SetFloat(float64(value))
+		default:
+			return offset, typeError(offset, h, v.Type())
+		}
+
+	case reflect.String:
+		switch h.Type {
+		case StrType:
+			value, _ := dec.parseValueString(input, offset)
+			v.SetString(value)
+		case BinType:
+			v.SetString(string((*input)[offset+h.Size : end]))
+		default:
+			return offset, typeError(offset, h, v.Type())
+		}
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (h.Type == BinType || h.Type == StrType) {
+			var b []byte
+			if dec.merge {
+				b = v.Bytes()[:0]
+			}
+			v.SetBytes(append(b, (*input)[offset+h.Size:end]...))
+			return end, nil
+		}
+		if h.Type != ArrayType {
+			return offset, typeError(offset, h, v.Type())
+		}
+		if dec.merge && !v.IsNil() && v.Cap() >= h.Length {
+			v.SetLen(h.Length)
+			zero := reflect.Zero(v.Type().Elem())
+			for i := 0; i < h.Length; i++ {
+				v.Index(i).Set(zero)
+			}
+		} else {
+			v.Set(reflect.MakeSlice(v.Type(), h.Length, h.Length))
+		}
+		return dec.unmarshalElements(input, offset+h.Size, h.Length, v)
+
+	case reflect.Array:
+		if h.Type != ArrayType || h.Length != v.Len() {
+			return offset, typeError(offset, h, v.Type())
+		}
+		return dec.unmarshalElements(input, offset+h.Size, h.Length, v)
+
+	case reflect.Map:
+		if h.Type != MapType {
+			return offset, typeError(offset, h, v.Type())
+		}
+		t := v.Type()
+		if !dec.merge || v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, h.Length))
+		}
+		offset += h.Size
+		// keys in this map, if it matters. Entries already in @v when
+		// merging don't count
+		var seen map[interface{}]bool
+		if dec.DuplicateKeys != LastWins {
+			seen = make(map[interface{}]bool, h.Length)
+		}
+		var err error
+		for i := 0; i < h.Length; i++ {
+			if err := dec.check.next(); err != nil {
+				return offset, err
+			}
+			key := reflect.New(t.Key()).Elem()
+			keyoffset := offset
+			if offset, err = dec.unmarshalKey(input, offset, key); err != nil {
+				return offset, err
+			}
+			if seen != nil && seen[key.Interface()] {
+				if dec.DuplicateKeys == ErrorOnDuplicate {
+					return keyoffset, &DuplicateKeyError{Key: fmt.Sprint(key.Interface()), Offset: keyoffset}
+				}
+				if offset, err = Skip(*input, offset); err != nil {
+					return offset, err
+				}
+				continue
+			}
+			if seen != nil {
+				seen[key.Interface()] = true
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if dec.merge {
+				if existing := v.MapIndex(key); existing.IsValid() {
+					elem.Set(existing)
+				}
+			}
+			if offset, err = dec.unmarshal(input, offset, elem); err != nil {
+				return offset, err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return offset, nil
+
+	case reflect.Struct:
+		switch h.Type {
+		case MapType:
+			return dec.unmarshalStruct(input, offset+h.Size, h.Length, v)
+		case ArrayType:
+			return dec.unmarshalStructArray(input, offset+h.Size, h.Length, v)
+		}
+		return offset, typeError(offset, h, v.Type())
+
+	default:
+		return offset, typeError(offset, h, v.Type())
+	}
+	return end, nil
+}
+
+var (
+	unmarshalerType   = reflect.TypeOf((*msgpack.Unmarshaler)(nil)).Elem()
+	customDecoderType = reflect.TypeOf((*msgpack.CustomDecoder)(nil)).Elem()
+)
+
+// reflect.Type -> bool
+var selfDecodings sync.Map
+
+// Returns true if values of type @t are left to vmihailenco to decode, the
+// way selfEncodingFor picks the ones it encodes
+func selfDecodingFor(t reflect.Type) bool {
+	if cached, found := selfDecodings.Load(t); found {
+		return cached.(bool)
+	}
+	pt := reflect.PtrTo(t)
+	self := t == timeType || pt.Implements(unmarshalerType) || pt.Implements(customDecoderType)
+	selfDecodings.Store(t, self)
+	return self
+}
+
+// Has vmihailenco decode the object at @offset into @v
+func (dec *decodeState) unmarshalSelf(input *[]byte, offset int, v reflect.Value) (int, error) {
+	end, err := Skip(*input, offset)
+	if err != nil {
+		return offset, err
+	}
+	target := v
+	if !v.CanAddr() {
+		target = reflect.New(v.Type()).Elem()
+	}
+	if err = msgpack.Unmarshal((*input)[offset:end], target.Addr().Interface()); err != nil {
+		return offset, &DecodeError{Offset: offset, Msg: fmt.Sprintf("cannot unmarshal into Go value of type %v: %v", v.Type(), err)}
+	}
+	if target != v {
+		v.Set(target)
+	}
+	return end, nil
+}
+
+// Decodes @n array elements starting at @offset into the slice or array @v
+func (dec *decodeState) unmarshalElements(input *[]byte, offset int, n int, v reflect.Value) (int, error) {
+	var err error
+	for i := 0; i < n; i++ {
+		if err := dec.check.next(); err != nil {
+			return offset, err
+		}
+		if offset, err = dec.unmarshal(input, offset, v.Index(i)); err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
+
+// Decodes a map key at @offset into @key, interning it if it is a string
+func (dec *decodeState) unmarshalKey(input *[]byte, offset int, key reflect.Value) (int, error) {
+	if key.Kind() == reflect.String && isString((*input)[offset]) {
+		value, consumed := dec.parseKey(input, offset)
+		key.SetString(value)
+		return offset + consumed, nil
+	}
+	return dec.unmarshal(input, offset, key)
+}
+
+var orderedMapType = reflect.TypeOf(OrderedMap{})
+
+// Decodes @n key/value pairs starting at @offset into the OrderedMap @v.
+// Values are decoded as if into an interface{}
+func (dec *decodeState) unmarshalOrderedMap(input *[]byte, offset int, n int, v reflect.Value) (int, error) {
+	m := make(OrderedMap, 0, n)
+	if dec.merge {
+		m = append(m, v.Interface().(OrderedMap)...)
+	}
+	var seen map[string]bool // keys in this map, if it matters
+	if dec.DuplicateKeys == FirstWins {
+		seen = make(map[string]bool, n)
+	}
+	// where each key is in m, once m is too long to search, as in
+	// parseOrderedMap
+	var index map[string]int
+	if len(m)+n > orderedIndexLength {
+		index = make(map[string]int, len(m)+n)
+		for i := range m {
+			if _, found := index[m[i].Key]; !found {
+				index[m[i].Key] = i
+			}
+		}
+	}
+	var err error
+	for i := 0; i < n; i++ {
+		if err := dec.check.next(); err != nil {
+			return offset, err
+		}
+		if !isString((*input)[offset]) {
+			h, _ := ReadHeader(*input, offset)
+			return offset, &DecodeError{Offset: offset, Msg: fmt.Sprintf("cannot use %v as a key for OrderedMap", h.Type)}
+		}
+		key, consumed := dec.parseKey(input, offset)
+		offset += consumed
+		var value interface{}
+		if offset, err = dec.unmarshal(input, offset, reflect.ValueOf(&value).Elem()); err != nil {
+			return offset, err
+		}
+		if seen != nil {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		existing := -1
+		if index != nil {
+			if i, found := index[key]; found {
+				existing = i
+			}
+		} else {
+			for i := range m {
+				if m[i].Key == key {
+					existing = i
+					break
+				}
+			}
+		}
+		if existing >= 0 {
+			m[existing].Value = value
+			continue
+		}
+		if index != nil {
+			index[key] = len(m)
+		}
+		m = append(m, KeyValue{Key: key, Value: value})
+	}
+	v.Set(reflect.ValueOf(m))
+	return offset, nil
+}
+
+// Decodes @n key/value pairs starting at @offset into the fields of the
+// struct @v
+func (dec *decodeState) unmarshalStruct(input *[]byte, offset int, n int, v reflect.Value) (int, error) {
+	plan := planFor(v.Type())
+	var seen []bool // which fields have been set, if it matters
+	var remainseen map[string]bool
+	if dec.DuplicateKeys != LastWins {
+		seen = make([]bool, len(plan.fields))
+		remainseen = make(map[string]bool)
+	}
+	if plan.remain != nil && !dec.merge {
+		if remain, ok := fieldToEncode(v, plan.remain); ok {
+			remain.Set(reflect.Zero(remainType))
+		}
+	}
+	var err error
+	for i := 0; i < n; i++ {
+		if err := dec.check.next(); err != nil {
+			return offset, err
+		}
+		if !isString((*input)[offset]) {
+			h, _ := ReadHeader(*input, offset)
+			return offset, &DecodeError{Offset: offset, Msg: fmt.Sprintf("cannot use %v as a key for Go struct %v", h.Type, v.Type())}
+		}
+		name, consumed := stringBytes(input, offset)
+		keyoffset := offset
+		offset += consumed
+		field := plan.lookup(name)
+		if field < 0 && plan.remain != nil {
+			if remainseen != nil {
+				if !remainseen[string(name)] {
+					remainseen[string(name)] = true
+				} else if dec.DuplicateKeys == ErrorOnDuplicate {
+					return keyoffset, &DuplicateKeyError{Key: string(name), Offset: keyoffset}
+				} else {
+					if offset, err = Skip(*input, offset); err != nil {
+						return offset, err
+					}
+					continue
+				}
+			}
+			if offset, err = dec.unmarshalRemain(input, offset, string(name), fieldToDecode(v, plan.remain)); err != nil {
+				return offset, err
+			}
+			continue
+		}
+		if field < 0 && dec.DisallowUnknownFields {
+			return keyoffset, &DecodeError{Offset: keyoffset, Msg: fmt.Sprintf("unknown field %q for Go struct %v", name, v.Type())}
+		}
+		if field >= 0 && seen != nil {
+			if !seen[field] {
+				seen[field] = true
+			} else if dec.DuplicateKeys == ErrorOnDuplicate {
+				return keyoffset, &DuplicateKeyError{Key: string(name), Offset: keyoffset}
+			} else {
+				field = -1
+			}
+		}
+		if field < 0 {
+			if offset, err = Skip(*input, offset); err != nil {
+				return offset, err
+			}
+			continue
+		}
+		if offset, err = dec.unmarshal(input, offset, fieldToDecode(v, plan.fields[field].index)); err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
+
+// Copies the object at @offset into the map[string]Raw @remain under @key,
+// making the map if it is nil
+func (dec *decodeState) unmarshalRemain(input *[]byte, offset int, key string, remain reflect.Value) (int, error) {
+	end, err := Skip(*input, offset)
+	if err != nil {
+		return offset, err
+	}
+	if remain.IsNil() {
+		remain.Set(reflect.MakeMap(remainType))
+	}
+	remain.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(Raw(append([]byte{}, (*input)[offset:end]...))))
+	return end, nil
+}
+
+// Decodes @n array elements starting at @offset into the fields of the
+// struct @v in order. Elements past the last field are skipped, and fields
+// past the last element are left alone
+func (dec *decodeState) unmarshalStructArray(input *[]byte, offset int, n int, v reflect.Value) (int, error) {
+	fields := planFor(v.Type()).fields
+	var err error
+	for i := 0; i < n; i++ {
+		if err := dec.check.next(); err != nil {
+			return offset, err
+		}
+		if i >= len(fields) {
+			offset, err = Skip(*input, offset)
+		} else {
+			offset, err = dec.unmarshal(input, offset, fieldToDecode(v, fields[i].index))
+		}
+		if err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
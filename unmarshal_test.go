@@ -0,0 +1,332 @@
+package msgpack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type unmarshalInner struct {
+	Tags []string
+}
+
+type unmarshalTarget struct {
+	Name    string
+	Count   int `msgpack:"count"`
+	Ratio   float64
+	Small   uint8
+	Enabled bool
+	Skipped string `msgpack:"-"`
+	Inner   *unmarshalInner
+	Values  map[string]int
+	Any     interface{}
+	Data    []byte
+	Pair    [2]int16
+	hidden  int
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	val := map[string]interface{}{
+		"Name":    "thing",
+		"count":   -1000,
+		"ratio":   int64(3), // case-insensitive match, int into float
+		"Small":   uint8(200),
+		"Enabled": true,
+		"Skipped": "no",
+		"Inner":   map[string]interface{}{"Tags": []interface{}{"a", "b"}},
+		"Values":  map[string]interface{}{"x": 1, "y": 2},
+		"Any":     []interface{}{"z"},
+		"Data":    "raw",
+		"Pair":    []interface{}{1, -2},
+		"hidden":  5,
+		"Unknown": map[string]interface{}{"deep": []interface{}{1, 2}},
+	}
+	bytes, err := Marshal(val)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var target unmarshalTarget
+	if err = Unmarshal(bytes, &target); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if target.Name != "thing" || target.Count != -1000 || target.Ratio != 3 || target.Small != 200 || !target.Enabled {
+		t.Errorf("Scalar fields were not filled in: %+v", target)
+	}
+	if target.Skipped != "" || target.hidden != 0 {
+		t.Errorf("Skipped and unexported fields should be left alone: %+v", target)
+	}
+	if target.Inner == nil || len(target.Inner.Tags) != 2 || target.Inner.Tags[1] != "b" {
+		t.Errorf("Inner should be {[a b]} but was %+v", target.Inner)
+	}
+	if len(target.Values) != 2 || target.Values["y"] != 2 {
+		t.Errorf("Values should be map[x:1 y:2] but was %v", target.Values)
+	}
+	if !compareInterfaceStringSlice(target.Any.([]interface{}), []interface{}{"z"}) {
+		t.Errorf("Any should be [z] but was %v", target.Any)
+	}
+	if string(target.Data) != "raw" || target.Pair != [2]int16{1, -2} {
+		t.Errorf("Data should be raw and Pair [1 -2] but were %v and %v", target.Data, target.Pair)
+	}
+}
+
+func TestUnmarshalStructRoundTrip(t *testing.T) {
+	val := unmarshalTarget{Name: "x", Count: 7, Inner: &unmarshalInner{Tags: []string{"t"}}, Data: []byte{1, 2}}
+	bytes, err := Marshal(val)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var target unmarshalTarget
+	if err = Unmarshal(bytes, &target); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if target.Name != "x" || target.Count != 7 || target.Inner.Tags[0] != "t" || len(target.Data) != 2 {
+		t.Errorf("Unmarshal should be %+v but was %+v", val, target)
+	}
+}
+
+func TestUnmarshalNil(t *testing.T) {
+	target := unmarshalTarget{Name: "set", Inner: &unmarshalInner{}}
+	bytes, _ := Append(nil, map[string]interface{}{"Name": nil, "Inner": nil})
+	if err := Unmarshal(bytes, &target); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if target.Name != "" || target.Inner != nil {
+		t.Errorf("nil should zero Name and Inner but they were %v and %v", target.Name, target.Inner)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var i8 int8
+	var u uint
+	var s string
+	var target unmarshalTarget
+	for _, test := range []struct {
+		input  []byte
+		target interface{}
+	}{
+		{[]byte{0xd1, 0x1, 0x0}, &i8},        // 256 overflows int8
+		{[]byte{0xd0, 0x80}, &u},             // negative into uint
+		{[]byte{0x1}, &s},                    // int into string
+		{[]byte{0x92, 0x1}, &s},              // truncated
+		{[]byte{0xc3, 0xc3}, &s},             // two objects
+		{[]byte{0x81, 0x1, 0x1}, &target},    // non-string key for a struct
+		{[]byte{0x81, 0xa1, 0x61, 0x1}, nil}, // not a pointer
+	} {
+		if err := Unmarshal(test.input, test.target); err == nil {
+			t.Errorf("Unmarshal(%x) into %T should have failed", test.input, test.target)
+		}
+	}
+}
+
+func TestUnmarshalDuplicateKeys(t *testing.T) {
+	//{'Name':'a','name':'b'}, duplicates once matched to the struct
+	structbytes := []byte{0x82, 0xa4, 0x4e, 0x61, 0x6d, 0x65, 0xa1, 0x61, 0xa4, 0x6e, 0x61, 0x6d, 0x65, 0xa1, 0x62}
+	//{1:'a',uint8 1:'b'}, duplicates once decoded into map[int]string
+	mapbytes := []byte{0x82, 0x1, 0xa1, 0x61, 0xcc, 0x1, 0xa1, 0x62}
+	for policy, want := range map[DuplicateKeyPolicy]string{LastWins: "b", FirstWins: "a"} {
+		dec := &Decoder{DuplicateKeys: policy}
+		var target unmarshalTarget
+		if err := dec.Unmarshal(structbytes, &target); err != nil || target.Name != want {
+			t.Errorf("policy %v set Name to %q (%v), want %q", policy, target.Name, err, want)
+		}
+		var m map[int]string
+		if err := dec.Unmarshal(mapbytes, &m); err != nil || m[1] != want {
+			t.Errorf("policy %v decoded %v (%v), want 1:%s", policy, m, err, want)
+		}
+	}
+
+	dec := &Decoder{DuplicateKeys: ErrorOnDuplicate}
+	var target unmarshalTarget
+	if err, ok := dec.Unmarshal(structbytes, &target).(*DuplicateKeyError); !ok || err.Key != "name" || err.Offset != 8 {
+		t.Errorf("Unmarshal into a struct returned %v, want duplicate name at 8", err)
+	}
+	var m map[int]string
+	if err, ok := dec.Unmarshal(mapbytes, &m).(*DuplicateKeyError); !ok || err.Key != "1" || err.Offset != 4 {
+		t.Errorf("Unmarshal into a map returned %v, want duplicate 1 at 4", err)
+	}
+	//{'a':1,'a':2}
+	var any interface{}
+	if _, ok := dec.Unmarshal([]byte{0x82, 0xa1, 0x61, 0x1, 0xa1, 0x61, 0x2}, &any).(*DuplicateKeyError); !ok {
+		t.Errorf("Unmarshal into an interface{} allowed a duplicate key")
+	}
+}
+
+func TestUnmarshalInvalidUTF8(t *testing.T) {
+	bytes := []byte{0x81, 0xa4, 0x4e, 0x61, 0x6d, 0x65, 0xa1, 0xff} //{'Name':'\xff'}
+	var target unmarshalTarget
+	if err := (&Decoder{InvalidUTF8: RejectInvalidUTF8}).Unmarshal(bytes, &target); err == nil {
+		t.Errorf("RejectInvalidUTF8 allowed a bad string")
+	}
+	if err := (&Decoder{InvalidUTF8: InvalidUTF8AsBin}).Unmarshal(bytes, &target); err != nil || target.Name != "\xff" {
+		t.Errorf("InvalidUTF8AsBin should still fill in a string field: %q (%v)", target.Name, err)
+	}
+	var any interface{}
+	if err := (&Decoder{InvalidUTF8: InvalidUTF8AsBin}).Unmarshal(bytes, &any); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if _, ok := any.(map[string]interface{})["Name"].([]byte); !ok {
+		t.Errorf("InvalidUTF8AsBin should give an interface{} a []byte, not %#v", any)
+	}
+}
+
+type forwardCompatible struct {
+	Name  string
+	Extra map[string]Raw `msgpack:",remain"`
+}
+
+func TestUnmarshalUnknownFields(t *testing.T) {
+	bytes, err := Marshal(map[string]interface{}{"Name": "a", "Later": []interface{}{1, "two"}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	strict := &Decoder{DisallowUnknownFields: true}
+	var target unmarshalTarget
+	if err, ok := strict.Unmarshal(bytes, &target).(*DecodeError); !ok || !strings.Contains(err.Msg, "Later") {
+		t.Errorf("strict Unmarshal returned %v, want an unknown field error", err)
+	}
+
+	// a remain field takes the unknown keys even in strict mode
+	var fc forwardCompatible
+	if err = strict.Unmarshal(bytes, &fc); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if fc.Name != "a" || len(fc.Extra) != 1 || !reflect.DeepEqual(fc.Extra["Later"], Raw{0x92, 0x1, 0xa3, 0x74, 0x77, 0x6f}) {
+		t.Errorf("Unknown key was not captured: %+v", fc)
+	}
+	fc.Name = "b"
+	reencoded, err := Marshal(fc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	_, decoded := Decode(&reencoded, 0)
+	want := map[string]interface{}{"Name": "b", "Later": []interface{}{int64(1), "two"}}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("Re-encoding gave %v, want %v", decoded, want)
+	}
+
+	// decoding again starts the remain field over
+	if err = Unmarshal([]byte{0x81, 0xa4, 0x4e, 0x61, 0x6d, 0x65, 0xa1, 0x63}, &fc); err != nil || fc.Extra != nil {
+		t.Errorf("Unknown keys were kept from before: %+v (%v)", fc, err)
+	}
+}
+
+func TestUnmarshalRaw(t *testing.T) {
+	var target struct {
+		Name  string
+		Later Raw
+	}
+	bytes := []byte{0x82, 0xa4, 0x4e, 0x61, 0x6d, 0x65, 0xa1, 0x61, 0xa5, 0x4c, 0x61, 0x74, 0x65, 0x72, 0x91, 0xc0}
+	if err := Unmarshal(bytes, &target); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(target.Later, Raw{0x91, 0xc0}) {
+		t.Errorf("Later should be 91c0 but was %x", target.Later)
+	}
+	reencoded, err := Marshal(map[string]interface{}{"Later": target.Later})
+	if err != nil || !reflect.DeepEqual(reencoded, []byte{0x81, 0xa5, 0x4c, 0x61, 0x74, 0x65, 0x72, 0x91, 0xc0}) {
+		t.Errorf("Raw should be written as is, got %x (%v)", reencoded, err)
+	}
+}
+
+type mergeLimits struct {
+	Max, Min int
+}
+
+type mergeConfig struct {
+	Name    string
+	Port    int
+	Limits  mergeLimits
+	Hosts   []string
+	Weights map[string]mergeLimits
+	Proxy   *string
+	Backup  *mergeLimits
+	Key     []byte
+}
+
+func TestUnmarshalInto(t *testing.T) {
+	proxy := "proxy:80"
+	hosts := make([]string, 1, 8)
+	hosts[0] = "old"
+	key := make([]byte, 0, 16)
+	weights := map[string]mergeLimits{"a": {Max: 1, Min: 1}, "b": {Max: 2, Min: 2}}
+	backup := &mergeLimits{Max: 5, Min: 5}
+	config := mergeConfig{
+		Name:    "default",
+		Port:    80,
+		Limits:  mergeLimits{Max: 10, Min: 1},
+		Hosts:   hosts,
+		Weights: weights,
+		Proxy:   &proxy,
+		Backup:  backup,
+		Key:     key,
+	}
+	update, err := Marshal(map[string]interface{}{
+		"Port":    8080,
+		"Limits":  map[string]interface{}{"Max": 20},
+		"Hosts":   []interface{}{"x", "y"},
+		"Weights": map[string]interface{}{"b": map[string]interface{}{"Min": 0}, "c": map[string]interface{}{"Max": 3}},
+		"Proxy":   nil,
+		"Backup":  map[string]interface{}{"Min": 4},
+		"Key":     "secret",
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if err = UnmarshalInto(update, &config); err != nil {
+		t.Fatalf("UnmarshalInto failed: %v", err)
+	}
+
+	if config.Name != "default" || config.Port != 8080 || config.Limits != (mergeLimits{Max: 20, Min: 1}) {
+		t.Errorf("Fields were not merged: %+v", config)
+	}
+	if len(config.Hosts) != 2 || config.Hosts[1] != "y" || &config.Hosts[0] != &hosts[0] {
+		t.Errorf("Hosts should be [x y] in the old backing array, got %v", config.Hosts)
+	}
+	expected := map[string]mergeLimits{"a": {1, 1}, "b": {2, 0}, "c": {3, 0}}
+	if !reflect.DeepEqual(config.Weights, expected) || reflect.ValueOf(config.Weights).Pointer() != reflect.ValueOf(weights).Pointer() {
+		t.Errorf("Weights should be merged into the old map, got %v", config.Weights)
+	}
+	if config.Proxy != nil {
+		t.Errorf("Proxy should be set to nil, got %v", *config.Proxy)
+	}
+	if config.Backup != backup || *backup != (mergeLimits{Max: 5, Min: 4}) {
+		t.Errorf("Backup should be merged in place, got %+v", config.Backup)
+	}
+	if string(config.Key) != "secret" || &config.Key[0] != &key[:1][0] {
+		t.Errorf("Key should reuse its buffer, got %q", config.Key)
+	}
+
+	// plain Unmarshal still replaces
+	if err = Unmarshal(update, &config); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(config.Weights) != 2 {
+		t.Errorf("Unmarshal should replace maps, got %v", config.Weights)
+	}
+}
+
+func TestUnmarshalIntoMapDuplicateKeys(t *testing.T) {
+	//{'a':1,'b':2,'a':3}
+	input := []byte{0x83, 0xa1, 0x61, 0x1, 0xa1, 0x62, 0x2, 0xa1, 0x61, 0x3}
+	for policy, want := range map[DuplicateKeyPolicy]int{LastWins: 3, FirstWins: 1} {
+		m := map[string]int{"a": 10, "c": 30}
+		if err := (&Decoder{DuplicateKeys: policy}).UnmarshalInto(input, &m); err != nil {
+			t.Fatalf("policy %v: UnmarshalInto failed: %v", policy, err)
+		}
+		expected := map[string]int{"a": want, "b": 2, "c": 30}
+		if !reflect.DeepEqual(m, expected) {
+			t.Errorf("policy %v merged %v, want %v", policy, m, expected)
+		}
+	}
+
+	// keys already in the map aren't duplicates
+	m := map[string]int{"a": 10}
+	update := []byte{0x81, 0xa1, 0x61, 0x1}
+	dec := &Decoder{DuplicateKeys: ErrorOnDuplicate}
+	if err := dec.UnmarshalInto(update, &m); err != nil || m["a"] != 1 {
+		t.Errorf("UnmarshalInto should update a, got %v (%v)", m, err)
+	}
+	if _, ok := dec.UnmarshalInto(input, &m).(*DuplicateKeyError); !ok {
+		t.Errorf("UnmarshalInto should still refuse a key repeated in the message")
+	}
+}
@@ -0,0 +1,140 @@
+package msgpack
+
+import (
+	"encoding/hex"
+	"unicode/utf8"
+)
+
+// Returns the offset just past the msgpack object starting at @offset,
+// without decoding it. Returns a *DecodeError if the object is malformed or
+// runs past the end of @input. Map keys may be of any type.
+func Skip(input []byte, offset int) (int, error) {
+	// objects still to be skipped; containers add their elements to this
+	// instead of recursing
+	remaining := 1
+	for remaining > 0 {
+		h, err := ReadHeader(input, offset)
+		if err != nil {
+			return offset, err
+		}
+		remaining--
+		offset += h.Size
+		switch h.Type {
+		case ArrayType:
+			remaining += h.Length
+		case MapType:
+			remaining += 2 * h.Length
+		default:
+			offset += h.Length
+		}
+	}
+	return offset, nil
+}
+
+// Like the package-level Skip, but also returns the errors Decode would
+// panic with: with ErrorOnDuplicate, a *DuplicateKeyError if any map in the
+// object repeats a key, and unless invalid UTF-8 is allowed, a *DecodeError
+// for a str that isn't UTF-8 (only keys with InvalidUTF8AsBin)
+func (dec *Decoder) Skip(input []byte, offset int) (int, error) {
+	if dec.DuplicateKeys != ErrorOnDuplicate && dec.InvalidUTF8 == AllowInvalidUTF8 {
+		return Skip(input, offset)
+	}
+	return dec.skipChecked(input, offset)
+}
+
+// A key as compared for duplicates
+type mapKey struct {
+	str bool
+	// the contents of a str, otherwise the whole encoding
+	s string
+}
+
+// An array or map skipChecked is inside of
+type openObject struct {
+	// objects left in it, counting map keys and values separately
+	remaining int
+	ismap     bool
+	// the keys seen so far, if they are being checked
+	keys map[mapKey]struct{}
+}
+
+// Skip, checking keys and strings as it goes
+func (dec *Decoder) skipChecked(input []byte, offset int) (int, error) {
+	open := []openObject{{remaining: 1}}
+	for len(open) > 0 {
+		top := &open[len(open)-1]
+		if top.remaining == 0 {
+			open = open[:len(open)-1]
+			continue
+		}
+		top.remaining--
+		h, err := ReadHeader(input, offset)
+		if err != nil {
+			return offset, err
+		}
+		// a map's keys come when an odd number of objects are left after them
+		iskey := top.ismap && top.remaining%2 == 1
+		if h.Type == StrType && (dec.InvalidUTF8 == RejectInvalidUTF8 || iskey && dec.InvalidUTF8 == InvalidUTF8AsBin) {
+			if !utf8.Valid(input[offset+h.Size : offset+h.Size+h.Length]) {
+				return offset, invalidUTF8Error(offset)
+			}
+		}
+		if iskey && dec.DuplicateKeys == ErrorOnDuplicate {
+			key := mapKey{str: h.Type == StrType}
+			if key.str {
+				key.s = string(input[offset+h.Size : offset+h.Size+h.Length])
+			} else {
+				end, err := Skip(input, offset)
+				if err != nil {
+					return offset, err
+				}
+				key.s = string(input[offset:end])
+			}
+			if _, found := top.keys[key]; found {
+				name := key.s
+				if !key.str {
+					name = hex.EncodeToString(input[offset : offset+len(key.s)])
+				}
+				return offset, &DuplicateKeyError{Key: name, Offset: offset}
+			}
+			top.keys[key] = struct{}{}
+		}
+		offset += h.Size
+		switch h.Type {
+		case ArrayType:
+			open = append(open, openObject{remaining: h.Length})
+		case MapType:
+			object := openObject{remaining: 2 * h.Length, ismap: true}
+			if dec.DuplicateKeys == ErrorOnDuplicate {
+				object.keys = make(map[mapKey]struct{}, h.Length)
+			}
+			open = append(open, object)
+		default:
+			offset += h.Length
+		}
+	}
+	return offset, nil
+}
+
+// Checks that @data holds one or more complete, well-formed msgpack
+// objects back to back and nothing else. Returns a *DecodeError describing
+// the first problem found.
+func Validate(data []byte) error {
+	return defaultDecoder.Validate(data)
+}
+
+// Like the package-level Validate, but with ErrorOnDuplicate also returns a
+// *DuplicateKeyError if any map repeats a key
+func (dec *Decoder) Validate(data []byte) error {
+	if len(data) == 0 {
+		return truncatedError(0)
+	}
+	offset := 0
+	for offset < len(data) {
+		var err error
+		if offset, err = dec.Skip(data, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package msgpack
+
+import (
+	"testing"
+)
+
+func TestSkip(t *testing.T) {
+	//[{'a':[1,2]},'b'] followed by nil
+	bytes := []byte{0x92, 0x81, 0xa1, 0x61, 0x92, 0x1, 0x2, 0xa1, 0x62, 0xc0}
+	offset, err := Skip(bytes, 0)
+	if err != nil {
+		t.Fatalf("Skip failed: %v", err)
+	}
+	if offset != 9 {
+		t.Errorf("Skip should end at offset 9 but ended at %v", offset)
+	}
+	offset, err = Skip(bytes, 1)
+	if err != nil || offset != 7 {
+		t.Errorf("Skip from the map should end at offset 7 but ended at %v (%v)", offset, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate([]byte{0x81, 0x1, 0xc4, 0x1, 0xff, 0xc0, 0xc3}); err != nil {
+		t.Errorf("Validate should accept any key type and back to back objects: %v", err)
+	}
+	for _, input := range [][]byte{
+		{},
+		{0x92, 0x1},
+		{0x81, 0xa1, 0x61},
+		{0xc1},
+		{0x91, 0xc4, 0x2, 0x1},
+	} {
+		if err := Validate(input); err == nil {
+			t.Errorf("Validate(%x) should have failed", input)
+		}
+	}
+}
+
+func TestValidateDuplicateKeys(t *testing.T) {
+	dec := &Decoder{DuplicateKeys: ErrorOnDuplicate}
+	for _, input := range [][]byte{
+		{0x82, 0xa1, 0x61, 0x1, 0xa1, 0x62, 0x2},             // {'a':1,'b':2}
+		{0x92, 0x81, 0xa1, 0x61, 0x1, 0x81, 0xa1, 0x61, 0x2}, // [{'a':1},{'a':2}]
+		{0x82, 0x1, 0x1, 0xcc, 0x1, 0x1},                     // {1:1, uint8 1:1}
+		{0x81, 0xa1, 0x61, 0x1, 0x81, 0xa1, 0x61, 0x1},       // {'a':1} twice
+	} {
+		if err := dec.Validate(input); err != nil {
+			t.Errorf("Validate(%x) failed: %v", input, err)
+		}
+	}
+	for _, c := range []struct {
+		input  []byte
+		key    string
+		offset int
+	}{
+		{[]byte{0x82, 0xa1, 0x61, 0x1, 0xd9, 0x1, 0x61, 0x2}, "a", 4},
+		{[]byte{0x91, 0x82, 0xa1, 0x62, 0x80, 0xa1, 0x62, 0xc0}, "b", 5},
+		{[]byte{0x82, 0x91, 0x1, 0xc0, 0x91, 0x1, 0xc0}, "9101", 4},
+	} {
+		err, ok := dec.Validate(c.input).(*DuplicateKeyError)
+		if !ok || err.Key != c.key || err.Offset != c.offset {
+			t.Errorf("Validate(%x) returned %v, want key %q at %d", c.input, err, c.key, c.offset)
+		}
+		if Validate(c.input) != nil {
+			t.Errorf("package Validate(%x) should allow duplicates", c.input)
+		}
+	}
+}
+
+func TestValidateInvalidUTF8(t *testing.T) {
+	value := []byte{0x81, 0xa1, 0x61, 0xa1, 0xff} //{'a':'\xff'}
+	key := []byte{0x81, 0xa1, 0xff, 0xa1, 0x61}   //{'\xff':'a'}
+	for _, c := range []struct {
+		policy     UTF8Policy
+		value, key bool
+	}{
+		{AllowInvalidUTF8, true, true},
+		{RejectInvalidUTF8, false, false},
+		{InvalidUTF8AsBin, true, false},
+	} {
+		dec := &Decoder{InvalidUTF8: c.policy}
+		if err := dec.Validate(value); (err == nil) != c.value {
+			t.Errorf("policy %v: Validate of a bad value returned %v", c.policy, err)
+		}
+		if err := dec.Validate(key); (err == nil) != c.key {
+			t.Errorf("policy %v: Validate of a bad key returned %v", c.policy, err)
+		}
+	}
+}